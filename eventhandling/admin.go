@@ -0,0 +1,31 @@
+package eventhandling
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// authorizeAdminRequest checks the bearer token of a request to an endpoint that changes the Prometheus
+// installation. The response is written if the request is rejected.
+func authorizeAdminRequest(rw http.ResponseWriter, req *http.Request) bool {
+	config, err := utils.GetConfig()
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return false
+	}
+	if config.AdminToken == "" {
+		writeJSON(rw, http.StatusForbidden, map[string]string{"message": "endpoint is disabled, ADMIN_TOKEN is not set"})
+		return false
+	}
+	header := req.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"message": "invalid or missing bearer token"})
+		return false
+	}
+	return true
+}
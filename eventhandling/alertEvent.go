@@ -23,7 +23,7 @@ import (
 type alertManagerEvent struct {
 	Receiver string  `json:"receiver"`
 	Status   string  `json:"status"`
-	Alerts   []alert `json:"alerts""`
+	Alerts   []alert `json:"alerts"`
 }
 
 type alert struct {
@@ -86,7 +86,7 @@ func ProcessAndForwardAlertEvent(rw http.ResponseWriter, requestBody []byte, log
 	}
 
 	logger.Debug("Sending event to eventbroker")
-	err = createAndSendCE(eventbroker, newProblemData, shkeptncontext)
+	err = createAndSendCE(newProblemData, shkeptncontext)
 	if err != nil {
 		logger.Error("Could not send cloud event: " + err.Error())
 		rw.WriteHeader(500)
@@ -96,11 +96,15 @@ func ProcessAndForwardAlertEvent(rw http.ResponseWriter, requestBody []byte, log
 	}
 }
 
-func createAndSendCE(eventbroker string, problemData keptn.ProblemEventData, shkeptncontext string) error {
+func createAndSendCE(problemData keptn.ProblemEventData, shkeptncontext string) error {
 	source, _ := url.Parse("prometheus")
 	contentType := "application/json"
 
-	endPoint, err := utils.GetServiceEndpoint(eventbroker)
+	config, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	endPoint, err := utils.GetServiceEndpoint(config.EventBrokerURL)
 
 	ce := cloudevents.Event{
 		Context: cloudevents.EventContextV02{
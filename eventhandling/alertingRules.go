@@ -0,0 +1,182 @@
+package eventhandling
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// keptnManagedAnnotation marks alerting rules that are created and owned by the prometheus-service.
+// Rules without this annotation are never modified or removed, except for the rules of earlier versions.
+const keptnManagedAnnotation = "keptn_managed"
+
+type alertingRules struct {
+	Groups []*alertingGroup `json:"groups" yaml:"groups"`
+	// Extra holds fields that are not known to this model, so they survive a rewrite of the rule file
+	Extra map[string]interface{} `json:"-" yaml:",inline"`
+}
+
+type alertingGroup struct {
+	Name     string                 `json:"name" yaml:"name"`
+	Interval string                 `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Limit    int                    `json:"limit,omitempty" yaml:"limit,omitempty"`
+	Rules    []*alertingRule        `json:"rules" yaml:"rules"`
+	Extra    map[string]interface{} `json:"-" yaml:",inline"`
+}
+
+// alertingRule is either an alerting rule (alert is set) or a recording rule (record is set)
+type alertingRule struct {
+	Record        string                 `json:"record,omitempty" yaml:"record,omitempty"`
+	Alert         string                 `json:"alert,omitempty" yaml:"alert,omitempty"`
+	Expr          string                 `json:"expr" yaml:"expr"`
+	For           string                 `json:"for,omitempty" yaml:"for,omitempty"`
+	KeepFiringFor string                 `json:"keep_firing_for,omitempty" yaml:"keep_firing_for,omitempty"`
+	Labels        map[string]string      `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations   map[string]string      `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Extra         map[string]interface{} `json:"-" yaml:",inline"`
+}
+
+// parseAlertingRules parses the content of a Prometheus rule file. An error is returned if the file cannot be parsed
+// or contains invalid rules, in which case the file must not be overwritten.
+func parseAlertingRules(content string) (*alertingRules, error) {
+	rules := &alertingRules{}
+	if strings.TrimSpace(content) == "" {
+		return rules, nil
+	}
+	if err := yaml.Unmarshal([]byte(content), rules); err != nil {
+		return nil, fmt.Errorf("could not parse prometheus.rules: %s", err.Error())
+	}
+
+	groupNames := map[string]bool{}
+	for _, group := range rules.Groups {
+		if group == nil {
+			return nil, errors.New("could not parse prometheus.rules: empty rule group")
+		}
+		if group.Name == "" {
+			return nil, errors.New("could not parse prometheus.rules: rule group without name")
+		}
+		if groupNames[group.Name] {
+			return nil, fmt.Errorf("could not parse prometheus.rules: duplicate rule group %s", group.Name)
+		}
+		groupNames[group.Name] = true
+
+		for _, rule := range group.Rules {
+			if rule == nil {
+				return nil, fmt.Errorf("could not parse prometheus.rules: empty rule in group %s", group.Name)
+			}
+			if (rule.Alert == "") == (rule.Record == "") {
+				return nil, fmt.Errorf("could not parse prometheus.rules: rule in group %s must have either alert or record set", group.Name)
+			}
+			if rule.Expr == "" {
+				return nil, fmt.Errorf("could not parse prometheus.rules: rule %s%s in group %s has no expr", rule.Alert, rule.Record, group.Name)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// key identifies a rule within its group
+func (r *alertingRule) key() string {
+	if r.Record != "" {
+		return "record:" + r.Record
+	}
+	return "alert:" + r.Alert
+}
+
+// isKeptnManaged returns true if the rule has been created by the prometheus-service. Recording rules cannot carry
+// annotations and are recognized by their name.
+func (r *alertingRule) isKeptnManaged() bool {
+	if r.Record != "" {
+		return strings.HasPrefix(r.Record, keptnRecordPrefix)
+	}
+	return r.Annotations != nil && r.Annotations[keptnManagedAnnotation] == "true"
+}
+
+// getAlertingGroupName returns the name of the rule group holding the alerts of a service in a stage
+func getAlertingGroupName(project string, stage string, service string) string {
+	return service + " " + project + "-" + stage + " alerts"
+}
+
+// isLegacyKeptnRule returns true if an alerting rule without the ownership annotation has been created by an earlier
+// version of the prometheus-service. These rules are sent to the keptn webhook with the labels of the service the
+// group belongs to.
+func (r *alertingRule) isLegacyKeptnRule(groupName string) bool {
+	if r.Alert == "" || r.Labels == nil || r.Labels["severity"] != "webhook" {
+		return false
+	}
+	project, stage, service := r.Labels["project"], r.Labels["stage"], r.Labels["service"]
+	return project != "" && stage != "" && service != "" && getAlertingGroupName(project, stage, service) == groupName
+}
+
+// mergeAlertingGroup updates the keptn managed rules of a group. Generated rules replace rules with the same name,
+// keptn managed rules that are no longer generated are removed and rules added by users are kept. Rules created by
+// earlier versions of the service do not carry the ownership annotation. They are recognized by their webhook labels,
+// so they are replaced by the annotated rules or removed once their SLI is no longer generated.
+func mergeAlertingGroup(rules *alertingRules, groupName string, generated []*alertingRule) {
+	for _, rule := range generated {
+		if rule.Record != "" {
+			continue
+		}
+		if rule.Annotations == nil {
+			rule.Annotations = map[string]string{}
+		}
+		rule.Annotations[keptnManagedAnnotation] = "true"
+	}
+
+	group := getAlertingGroup(rules, groupName)
+	if group == nil {
+		if len(generated) == 0 {
+			return
+		}
+		rules.Groups = append(rules.Groups, &alertingGroup{
+			Name:  groupName,
+			Rules: generated,
+		})
+		return
+	}
+
+	var merged []*alertingRule
+	written := map[string]bool{}
+	for _, rule := range group.Rules {
+		name := rule.key()
+		if newRule := getAlertingRule(generated, name); newRule != nil {
+			if !written[name] {
+				merged = append(merged, newRule)
+				written[name] = true
+			}
+			continue
+		}
+		if rule.isKeptnManaged() || rule.isLegacyKeptnRule(groupName) {
+			continue
+		}
+		merged = append(merged, rule)
+	}
+	for _, rule := range generated {
+		name := rule.key()
+		if !written[name] {
+			merged = append(merged, rule)
+			written[name] = true
+		}
+	}
+	group.Rules = merged
+}
+
+func getAlertingRule(rules []*alertingRule, key string) *alertingRule {
+	for _, rule := range rules {
+		if rule.key() == key {
+			return rule
+		}
+	}
+	return nil
+}
+
+func getAlertingGroup(alertingRulesConfig *alertingRules, groupName string) *alertingGroup {
+	for _, alertingGroup := range alertingRulesConfig.Groups {
+		if alertingGroup.Name == groupName {
+			return alertingGroup
+		}
+	}
+	return nil
+}
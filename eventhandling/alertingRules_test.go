@@ -0,0 +1,172 @@
+package eventhandling
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAlertingRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantGroups []string
+		wantErr    bool
+	}{
+		{name: "empty file", content: "  \n", wantGroups: nil},
+		{
+			name: "groups and unknown fields",
+			content: `groups:
+- name: sockshop-dev-carts-alerts
+  partial_response_strategy: warn
+  rules:
+  - alert: response_time_p90
+    expr: histogram_quantile(0.9, x) > 200
+  - record: keptn_sli:response_time_p90
+    expr: histogram_quantile(0.9, x)
+- name: custom
+  rules: []
+`,
+			wantGroups: []string{"sockshop-dev-carts-alerts", "custom"},
+		},
+		{name: "invalid yaml", content: "groups: [", wantErr: true},
+		{name: "group without name", content: "groups:\n- rules: []\n", wantErr: true},
+		{name: "empty group", content: "groups:\n- \n", wantErr: true},
+		{name: "duplicate group", content: "groups:\n- name: a\n- name: a\n", wantErr: true},
+		{name: "empty rule", content: "groups:\n- name: a\n  rules:\n  - \n", wantErr: true},
+		{name: "rule without alert or record", content: "groups:\n- name: a\n  rules:\n  - expr: up\n", wantErr: true},
+		{name: "rule with alert and record", content: "groups:\n- name: a\n  rules:\n  - alert: x\n    record: y\n    expr: up\n", wantErr: true},
+		{name: "rule without expr", content: "groups:\n- name: a\n  rules:\n  - alert: x\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseAlertingRules(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			var groups []string
+			for _, group := range rules.Groups {
+				groups = append(groups, group.Name)
+			}
+			if !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("expected groups %v, got %v", tt.wantGroups, groups)
+			}
+		})
+	}
+}
+
+func TestMergeAlertingGroup(t *testing.T) {
+	groupName := getAlertingGroupName("sockshop", "dev", "carts")
+	managed := map[string]string{keptnManagedAnnotation: "true"}
+	legacy := getAlertLabels("sockshop", "dev", "carts")
+
+	tests := []struct {
+		name      string
+		existing  []*alertingRule
+		generated []*alertingRule
+		// want are the keys and expressions of the merged rules, in order
+		want [][2]string
+	}{
+		{
+			name:      "group is created",
+			generated: []*alertingRule{{Alert: "response_time_p90", Expr: "new"}},
+			want:      [][2]string{{"alert:response_time_p90", "new"}},
+		},
+		{
+			name: "generated rules replace rules in place",
+			existing: []*alertingRule{
+				{Alert: "user_alert", Expr: "user"},
+				{Alert: "response_time_p90", Expr: "old", Annotations: managed},
+				{Record: keptnRecordPrefix + "response_time_p90", Expr: "old"},
+			},
+			generated: []*alertingRule{
+				{Record: keptnRecordPrefix + "response_time_p90", Expr: "new"},
+				{Alert: "response_time_p90", Expr: "new"},
+			},
+			want: [][2]string{
+				{"alert:user_alert", "user"},
+				{"alert:response_time_p90", "new"},
+				{"record:" + keptnRecordPrefix + "response_time_p90", "new"},
+			},
+		},
+		{
+			name: "managed rules no longer generated are removed",
+			existing: []*alertingRule{
+				{Alert: "error_rate", Expr: "old", Annotations: managed},
+				{Record: keptnRecordPrefix + "error_rate", Expr: "old"},
+				{Record: "user:error_rate", Expr: "user"},
+				{Alert: "user_alert", Expr: "user", Annotations: map[string]string{"summary": "user"}},
+			},
+			generated: []*alertingRule{{Alert: "response_time_p90", Expr: "new"}},
+			want: [][2]string{
+				{"record:user:error_rate", "user"},
+				{"alert:user_alert", "user"},
+				{"alert:response_time_p90", "new"},
+			},
+		},
+		{
+			name: "all managed rules are removed if nothing is generated",
+			existing: []*alertingRule{
+				{Alert: "error_rate", Expr: "old", Annotations: managed},
+				{Alert: "user_alert", Expr: "user"},
+			},
+			want: [][2]string{{"alert:user_alert", "user"}},
+		},
+		{
+			name: "rules of earlier versions no longer generated are removed",
+			existing: []*alertingRule{
+				{Alert: "error_rate", Expr: "old", Labels: legacy},
+				{Alert: "response_time_p90", Expr: "old", Labels: legacy},
+				{Alert: "other_service", Expr: "user", Labels: getAlertLabels("sockshop", "dev", "orders")},
+				{Alert: "user_alert", Expr: "user", Labels: map[string]string{"severity": "webhook"}},
+			},
+			generated: []*alertingRule{{Alert: "response_time_p90", Expr: "new"}},
+			want: [][2]string{
+				{"alert:response_time_p90", "new"},
+				{"alert:other_service", "user"},
+				{"alert:user_alert", "user"},
+			},
+		},
+		{
+			name: "duplicate rules of earlier versions are written once",
+			existing: []*alertingRule{
+				{Alert: "response_time_p90", Expr: "old"},
+				{Alert: "response_time_p90", Expr: "old"},
+			},
+			generated: []*alertingRule{{Alert: "response_time_p90", Expr: "new"}},
+			want:      [][2]string{{"alert:response_time_p90", "new"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &alertingRules{Groups: []*alertingGroup{{Name: "other", Rules: []*alertingRule{{Alert: "other", Expr: "up"}}}}}
+			if tt.existing != nil {
+				rules.Groups = append(rules.Groups, &alertingGroup{Name: groupName, Rules: tt.existing})
+			}
+			mergeAlertingGroup(rules, groupName, tt.generated)
+
+			if other := getAlertingGroup(rules, "other"); other == nil || len(other.Rules) != 1 {
+				t.Errorf("expected the other group to be unchanged")
+			}
+			group := getAlertingGroup(rules, groupName)
+			if group == nil {
+				t.Fatalf("expected group %s", groupName)
+			}
+			var got [][2]string
+			for _, rule := range group.Rules {
+				got = append(got, [2]string{rule.key(), rule.Expr})
+				if rule.Alert != "" && rule.Expr == "new" && !rule.isKeptnManaged() {
+					t.Errorf("expected generated rule %s to be marked as keptn managed", rule.key())
+				}
+				if rule.Record != "" && rule.Annotations != nil {
+					t.Errorf("expected recording rule %s without annotations", rule.key())
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected rules %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
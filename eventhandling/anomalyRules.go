@@ -0,0 +1,143 @@
+package eventhandling
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+const (
+	// anomalyZScore compares an SLI with its average over the learning window, in standard deviations
+	anomalyZScore = "zscore"
+	// anomalyWeek compares an SLI with its value at the same time one week earlier, as ratio of the earlier value
+	anomalyWeek = "week"
+
+	defaultZScoreSensitivity = 3
+	defaultWeekSensitivity   = 0.5
+
+	// keptnRecordPrefix starts the names of the recording rules created by the prometheus-service
+	keptnRecordPrefix = "keptn_sli:"
+)
+
+// anomalyCriterionPattern matches the anomaly criteria of an objective, e.g. anomaly(zscore), anomaly(zscore, 2.5)
+// or anomaly(week, 30%)
+var anomalyCriterionPattern = regexp.MustCompile(`^anomaly\(\s*([a-z]+)\s*(?:,\s*([0-9.]+%?)\s*)?\)$`)
+
+// anomalyCriterion is an objective that is violated if an SLI deviates from its usual values by more than the
+// sensitivity
+type anomalyCriterion struct {
+	method      string
+	sensitivity float64
+}
+
+// parseAnomalyCriterion parses an anomaly criterion. ok is false if the criterion uses another syntax.
+func parseAnomalyCriterion(criterion string) (c *anomalyCriterion, ok bool, err error) {
+	criterion = strings.TrimSpace(criterion)
+	if !strings.HasPrefix(criterion, "anomaly(") {
+		return nil, false, nil
+	}
+	match := anomalyCriterionPattern.FindStringSubmatch(criterion)
+	if match == nil {
+		return nil, true, fmt.Errorf("invalid anomaly criterion %s", criterion)
+	}
+	c = &anomalyCriterion{method: match[1]}
+	switch c.method {
+	case anomalyZScore:
+		c.sensitivity = defaultZScoreSensitivity
+	case anomalyWeek:
+		c.sensitivity = defaultWeekSensitivity
+	default:
+		return nil, true, fmt.Errorf("unknown anomaly detection %s in criterion %s, must be %s or %s", c.method, criterion, anomalyZScore, anomalyWeek)
+	}
+	if match[2] != "" {
+		value := strings.TrimSuffix(match[2], "%")
+		sensitivity, err := strconv.ParseFloat(value, 64)
+		if err != nil || sensitivity <= 0 {
+			return nil, true, fmt.Errorf("invalid sensitivity %s in criterion %s", match[2], criterion)
+		}
+		if strings.HasSuffix(match[2], "%") {
+			sensitivity = sensitivity / 100
+		}
+		c.sensitivity = sensitivity
+	}
+	return c, true, nil
+}
+
+// getAnomalyRules returns the alerting rule of an anomaly criterion of an SLI together with the recording rules it
+// is based on. The SLI is recorded with the labels of the service, so its history is available to the alert.
+func getAnomalyRules(config *utils.ServiceConfig, project string, stage string, service string, sli string, expr string, criterion *anomalyCriterion) ([]*alertingRule, error) {
+	record := keptnRecordPrefix + sli
+	if !model.IsValidMetricName(model.LabelValue(record)) {
+		return nil, fmt.Errorf("SLI %s cannot be recorded, the name is not a valid metric name", sli)
+	}
+	recordLabels := map[string]string{
+		"project": project,
+		"stage":   stage,
+		"service": service,
+	}
+	serviceMatchers := []*promlabels.Matcher{
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "project", project),
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "stage", stage),
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "service", service),
+	}
+	current, err := getSelector(record, serviceMatchers, nil)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*alertingRule{
+		{
+			Record: record,
+			Expr:   expr,
+			Labels: recordLabels,
+		},
+	}
+	sensitivity := strconv.FormatFloat(criterion.sensitivity, 'f', -1, 64)
+
+	var alert *alertingRule
+	switch criterion.method {
+	case anomalyZScore:
+		window := config.AnomalyWindow
+		// e.g. keptn_sli:response_time_p95:avg_over_time_1d
+		avgRecord := record + ":avg_over_time_" + window
+		stddevRecord := record + ":stddev_over_time_" + window
+		rules = append(rules,
+			&alertingRule{Record: avgRecord, Expr: "avg_over_time(" + current + "[" + window + "])", Labels: recordLabels},
+			&alertingRule{Record: stddevRecord, Expr: "stddev_over_time(" + current + "[" + window + "])", Labels: recordLabels},
+		)
+		avg, err := getSelector(avgRecord, serviceMatchers, nil)
+		if err != nil {
+			return nil, err
+		}
+		stddev, err := getSelector(stddevRecord, serviceMatchers, nil)
+		if err != nil {
+			return nil, err
+		}
+		alert = &alertingRule{
+			Alert: sli + "_zscore",
+			// a constant SLI has no deviation, the division then yields no result instead of an alert
+			Expr: "abs((" + current + " - " + avg + ") / (" + stddev + " > 0)) > " + sensitivity,
+			Annotations: map[string]string{
+				"summary":      sli + "_zscore",
+				"descriptions": sli + " deviates more than " + sensitivity + " standard deviations from its average of the last " + window,
+			},
+		}
+	case anomalyWeek:
+		alert = &alertingRule{
+			Alert: sli + "_week_over_week",
+			Expr:  "abs(" + current + " / (" + current + " offset 1w > 0) - 1) > " + sensitivity,
+			Annotations: map[string]string{
+				"summary":      sli + "_week_over_week",
+				"descriptions": sli + " deviates more than " + strconv.FormatFloat(criterion.sensitivity*100, 'f', -1, 64) + "% from its value one week ago",
+			},
+		}
+	}
+	alert.For = config.AlertDuration
+	alert.Labels = getAlertLabels(project, stage, service)
+	return append(rules, alert), nil
+}
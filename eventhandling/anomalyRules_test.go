@@ -0,0 +1,115 @@
+package eventhandling
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+func TestParseAnomalyCriterion(t *testing.T) {
+	tests := []struct {
+		name      string
+		criterion string
+		want      *anomalyCriterion
+		wantOK    bool
+		wantErr   bool
+	}{
+		{name: "threshold", criterion: "<600", wantOK: false},
+		{name: "relative threshold", criterion: "<=+10%", wantOK: false},
+		{name: "zscore with default sensitivity", criterion: "anomaly(zscore)", want: &anomalyCriterion{method: anomalyZScore, sensitivity: 3}, wantOK: true},
+		{name: "week with default sensitivity", criterion: "anomaly(week)", want: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.5}, wantOK: true},
+		{name: "zscore with sensitivity", criterion: "anomaly(zscore, 2.5)", want: &anomalyCriterion{method: anomalyZScore, sensitivity: 2.5}, wantOK: true},
+		{name: "percent sensitivity", criterion: "anomaly(week, 30%)", want: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.3}, wantOK: true},
+		{name: "ratio sensitivity", criterion: "anomaly(week, 0.3)", want: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.3}, wantOK: true},
+		{name: "surrounding spaces", criterion: "  anomaly( week ,30% )  ", want: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.3}, wantOK: true},
+		{name: "unknown method", criterion: "anomaly(mad)", wantOK: true, wantErr: true},
+		{name: "zero sensitivity", criterion: "anomaly(zscore, 0)", wantOK: true, wantErr: true},
+		{name: "invalid sensitivity", criterion: "anomaly(zscore, 1.2.3)", wantOK: true, wantErr: true},
+		{name: "negative sensitivity", criterion: "anomaly(zscore, -1)", wantOK: true, wantErr: true},
+		{name: "missing parenthesis", criterion: "anomaly(zscore", wantOK: true, wantErr: true},
+		{name: "upper case method", criterion: "anomaly(ZSCORE)", wantOK: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parseAnomalyCriterion(tt.criterion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("expected ok %v, got %v", tt.wantOK, ok)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetAnomalyRules(t *testing.T) {
+	config := &utils.ServiceConfig{AlertDuration: "10m", AnomalyWindow: "1d"}
+	tests := []struct {
+		name      string
+		sli       string
+		criterion *anomalyCriterion
+		wantNames []string
+		wantExpr  string
+		wantErr   bool
+	}{
+		{
+			name:      "zscore",
+			sli:       "response_time_p95",
+			criterion: &anomalyCriterion{method: anomalyZScore, sensitivity: 2.5},
+			wantNames: []string{
+				"keptn_sli:response_time_p95",
+				"keptn_sli:response_time_p95:avg_over_time_1d",
+				"keptn_sli:response_time_p95:stddev_over_time_1d",
+				"response_time_p95_zscore",
+			},
+			wantExpr: `abs((keptn_sli:response_time_p95{project="sockshop",service="carts",stage="dev"} - keptn_sli:response_time_p95:avg_over_time_1d{project="sockshop",service="carts",stage="dev"}) / (keptn_sli:response_time_p95:stddev_over_time_1d{project="sockshop",service="carts",stage="dev"} > 0)) > 2.5`,
+		},
+		{
+			name:      "week",
+			sli:       "throughput",
+			criterion: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.3},
+			wantNames: []string{"keptn_sli:throughput", "throughput_week_over_week"},
+			wantExpr:  `abs(keptn_sli:throughput{project="sockshop",service="carts",stage="dev"} / (keptn_sli:throughput{project="sockshop",service="carts",stage="dev"} offset 1w > 0) - 1) > 0.3`,
+		},
+		{
+			name:      "SLI that is not a valid metric name",
+			sli:       "response-time",
+			criterion: &anomalyCriterion{method: anomalyWeek, sensitivity: 0.3},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := getAnomalyRules(config, "sockshop", "dev", "carts", tt.sli, "sum(rate(http_requests_total[3m]))", tt.criterion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			var names []string
+			for _, rule := range rules {
+				names = append(names, rule.Record+rule.Alert)
+				if _, err := parser.ParseExpr(rule.Expr); err != nil {
+					t.Errorf("invalid expression of %s: %s", rule.key(), err.Error())
+				}
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("expected rules %v, got %v", tt.wantNames, names)
+			}
+			alert := rules[len(rules)-1]
+			if alert.Expr != tt.wantExpr {
+				t.Errorf("expected %s, got %s", tt.wantExpr, alert.Expr)
+			}
+			if alert.For != config.AlertDuration || alert.Labels["service"] != "carts" {
+				t.Errorf("unexpected duration %s or labels %v", alert.For, alert.Labels)
+			}
+		})
+	}
+}
@@ -7,10 +7,9 @@ import (
 
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go"
@@ -18,21 +17,16 @@ import (
 	cloudeventshttp "github.com/cloudevents/sdk-go/pkg/cloudevents/transport/http"
 	"github.com/cloudevents/sdk-go/pkg/cloudevents/types"
 	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/google/uuid"
-	kubeutils "github.com/keptn/kubernetes-utils/pkg"
 
 	"github.com/keptn-contrib/prometheus-service/utils"
 
-	"github.com/keptn/go-utils/pkg/api/models"
 	configutils "github.com/keptn/go-utils/pkg/api/utils"
 	"github.com/keptn/go-utils/pkg/lib"
-
-	prometheus_model "github.com/prometheus/common/model"
-	prometheusconfig "github.com/prometheus/prometheus/config"
-	prometheus_sd_config "github.com/prometheus/prometheus/discovery/config"
-	"github.com/prometheus/prometheus/discovery/targetgroup"
 )
 
 const Throughput = "throughput"
@@ -40,47 +34,40 @@ const ErrorRate = "error_rate"
 const ResponseTimeP50 = "response_time_p50"
 const ResponseTimeP90 = "response_time_p90"
 const ResponseTimeP95 = "response_time_p95"
-
-const configservice = "CONFIGURATION_SERVICE"
-const eventbroker = "EVENTBROKER"
-const api = "API"
+const ResponseTimeP99 = "response_time_p99"
+const Availability = "availability"
+const Apdex = "apdex"
+const CPUSaturation = "cpu_saturation"
+const MemorySaturation = "memory_saturation"
+const PodRestarts = "pod_restarts"
+const ReadyReplicaRatio = "ready_replica_ratio"
 
 const keptnPrometheusSLIConfigMapName = "prometheus-sli-config"
 
 type doneEventData struct {
 	Result  string `json:"result"`
 	Message string `json:"message"`
-	Version string `json:"version"`
-}
-
-type alertingRules struct {
-	Groups []*alertingGroup `json:"groups" yaml:"groups"`
-}
-
-type alertingGroup struct {
-	Name  string          `json:"name" yaml:"name"`
-	Rules []*alertingRule `json:"rules" yaml:"rules"`
-}
-
-type alertingRule struct {
-	Alert       string               `json:"alert" yaml:"alert"`
-	Expr        string               `json:"expr" yaml:"expr"`
-	For         string               `json:"for" yaml:"for"`
-	Labels      *alertingLabel       `json:"labels" yaml:"labels"`
-	Annotations *alertingAnnotations `json:"annotations" yaml:"annotations"`
+	// Version is the version of the last commit to the configuration-service, Versions holds the version of each
+	// configured service and stage
+	Version      string                  `json:"version"`
+	Versions     []*resourceVersion      `json:"versions,omitempty"`
+	Installation *prometheusInstallation `json:"installation,omitempty"`
+	Services     []string                `json:"services,omitempty"`
+	Health       []*componentHealth      `json:"health,omitempty"`
+	Verification *verificationReport     `json:"verification,omitempty"`
+	Plan         *configurePlan          `json:"plan,omitempty"`
 }
 
-type alertingLabel struct {
-	Severity string `json:"severity" yaml:"severity"`
-	PodName  string `json:"pod_name,omitempty" yaml:"pod_name"`
-	Service  string `json:"service,omitempty" yaml:"service"`
-	Stage    string `json:"stage,omitempty" yaml:"stage"`
-	Project  string `json:"project,omitempty" yaml:"project"`
-}
-
-type alertingAnnotations struct {
-	Summary     string `json:"summary" yaml:"summary"`
-	Description string `json:"description" yaml:"descriptions"`
+// configureResult holds the outcome of a configure-monitoring event that is reported in the done event
+type configureResult struct {
+	versions     []*resourceVersion
+	installation *prometheusInstallation
+	// services are the configured services
+	services     []string
+	health       []*componentHealth
+	verification *verificationReport
+	// plan is set for dry runs, which do not apply any changes
+	plan *configurePlan
 }
 
 // GotEvent is the event handler of cloud events
@@ -108,7 +95,12 @@ func GotEvent(ctx context.Context, event cloudevents.Event) error {
 			logger = stdLogger
 			logger.Debug("No Websocket connection data available")
 		} else {
-			apiServiceURL, err := utils.GetServiceEndpoint(api)
+			config, err := utils.GetConfig()
+			if err != nil {
+				stdLogger.Error(err.Error())
+				return nil
+			}
+			apiServiceURL, err := utils.GetServiceEndpoint(config.APIURL)
 			if err != nil {
 				logger.Error(err.Error())
 				return nil
@@ -124,13 +116,27 @@ func GotEvent(ctx context.Context, event cloudevents.Event) error {
 			logger = combinedLogger
 		}
 
-		keptnHandler, err := keptn.NewKeptn(&event, keptn.KeptnOpts{})
+		keptnHandler, err := keptn.NewKeptn(&event, keptn.KeptnOpts{ConfigurationServiceURL: getConfigurationServiceURL()})
 		if err != nil {
 			logger.Error("Could not initialize Keptn handler: " + err.Error())
 		}
 
-		version, err := configurePrometheusAndStoreResources(eventData, logger, keptnHandler)
-		if err := logErrAndRespondWithDoneEvent(event, version, err, logger); err != nil {
+		options := &configureOptions{}
+		_ = event.DataAs(options)
+		if options.DryRun {
+			plan, err := planPrometheusConfig(eventData, options, logger, keptnHandler)
+			result := &configureResult{plan: plan}
+			if plan != nil {
+				result.installation = plan.Installation
+			}
+			if err := logErrAndRespondWithDoneEvent(event, result, err, logger); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		result, err := configurePrometheusAndStoreResources(eventData, options, logger, keptnHandler)
+		if err := logErrAndRespondWithDoneEvent(event, result, err, logger); err != nil {
 			return err
 		}
 
@@ -144,86 +150,132 @@ func GotEvent(ctx context.Context, event cloudevents.Event) error {
 	return errors.New(errorMsg)
 }
 
-// configurePrometheusAndStoreResources
-func configurePrometheusAndStoreResources(eventData *keptn.ConfigureMonitoringEventData, logger keptn.LoggerInterface, keptnHandler *keptn.Keptn) (*models.Version, error) {
-	// (1) check if prometheus is installed, otherwise install prometheus and alert manager
-	if !isPrometheusInstalled(logger) {
-		logger.Debug("Installing prometheus monitoring")
-		err := installPrometheus(logger)
-		if err != nil {
-			return nil, err
-		}
+// configurePrometheusAndStoreResources configures Prometheus for the services of the event and stores the generated
+// configuration in the configuration-service. The config map is written and Prometheus is restarted once for all
+// services.
+func configurePrometheusAndStoreResources(eventData *keptn.ConfigureMonitoringEventData, options *configureOptions, logger keptn.LoggerInterface, keptnHandler *keptn.Keptn) (*configureResult, error) {
+	result := &configureResult{}
 
-		logger.Debug("Installing prometheus alert manager")
-		err = installPrometheusAlertManager(logger)
-		if err != nil {
-			return nil, err
-		}
+	// (1) find the prometheus installation, otherwise install prometheus and alert manager
+	installation, err := ensurePrometheusInstallation(logger)
+	if err != nil {
+		return result, err
 	}
-	fmt.Println("prometheus is installed, updating config maps")
+	result.installation = installation
+	logger.Debug("prometheus is installed, updating config maps")
 
-	// (2) update config map with alert rule
-	if err := updatePrometheusConfigMap(*eventData, logger, keptnHandler); err != nil {
-		return nil, err
+	// (2) create scrape jobs and alert rules for all stages of the services
+	desiredConfig, err := getMonitoringConfig(*eventData, options, logger, keptnHandler)
+	if err != nil {
+		return result, err
+	}
+	result.services = desiredConfig.services()
+	if len(result.services) == 0 {
+		return result, fmt.Errorf("no services found in project %s", eventData.Project)
 	}
+	logger.Info(fmt.Sprintf("Configuring monitoring for %d service(s): %s", len(result.services), strings.Join(result.services, ", ")))
 
-	// (2.1) delete prometheus pod
-	err := deletePrometheusPod()
-	if err != nil {
-		return nil, err
+	// (2.1) update config map with scrape jobs and alert rules and restart prometheus
+	if err := applyPrometheusConfig(desiredConfig, keptnHandler.KeptnContext, "configure monitoring for service(s) "+strings.Join(result.services, ", "), logger); err != nil {
+		return result, err
 	}
 
-	return nil, nil
+	// the configuration is live from here on, so a failed verification is reported after the resources have been
+	// stored, which keeps them in sync with the cluster
+	verifyErr := verifyAppliedConfig(installation, desiredConfig, result, logger)
+
+	// (3) store scrape jobs and alert rules as resources of the service
+	result.versions, err = storeMonitoringResources(desiredConfig, logger)
+	if err != nil && verifyErr != nil {
+		return result, fmt.Errorf("%s; %s", verifyErr.Error(), err.Error())
+	} else if err != nil {
+		return result, err
+	}
+	return result, verifyErr
 }
 
-func deletePrometheusPod() error {
+// verifyAppliedConfig reports the health of Prometheus and Alertmanager and verifies that the generated rules are
+// loaded and the scrape targets are up
+func verifyAppliedConfig(installation *prometheusInstallation, desiredConfig *monitoringConfig, result *configureResult, logger keptn.LoggerInterface) error {
+	var err error
+	// (2.4) report the health of prometheus and alert manager
+	result.health, err = waitForInstallationReady(installation, logger)
+	if err != nil {
+		return fmt.Errorf("%s is %s", installation.String(), err.Error())
+	}
 
-	if err := kubeutils.RestartPodsWithSelector(os.Getenv("env") == "production", "monitoring", "app=prometheus-server"); err != nil {
+	// (2.5) verify that the generated rules are loaded and the scrape targets are up
+	result.verification, err = verifyMonitoringConfig(installation, desiredConfig, logger)
+	if err != nil {
 		return err
 	}
+	if failed := result.verification.getFailedRules(); len(failed) > 0 {
+		return fmt.Errorf("Prometheus did not load the generated rules: %s", strings.Join(failed, ", "))
+	}
+	if summary := result.verification.summary(); summary != "" {
+		logger.Info("Service does not expose metrics yet: " + summary)
+	}
 	return nil
 }
 
-func isPrometheusInstalled(logger keptn.LoggerInterface) bool {
-	logger.Debug("Check if prometheus service in monitoring namespace is available")
-	config, err := rest.InClusterConfig()
+// applyPrometheusConfig updates the config map, restarts Prometheus and rolls back to the previous configuration if
+// Prometheus does not come up with the new one
+func applyPrometheusConfig(desiredConfig *monitoringConfig, keptnContext string, reason string, logger keptn.LoggerInterface) error {
+	revision, err := updatePrometheusConfigMap(desiredConfig, keptnContext, reason, logger)
 	if err != nil {
-		logger.Debug(fmt.Sprintf("Could not initialize kubernetes client %s", err.Error()))
-		return false
+		return err
 	}
-	api, err := kubernetes.NewForConfig(config)
 
-	if err != nil {
-		logger.Debug(fmt.Sprintf("Could not initialize kubernetes client %s", err.Error()))
-		return false
+	if err := deletePrometheusPod(); err != nil {
+		return err
 	}
 
-	_, err = api.CoreV1().Services("monitoring").Get("prometheus-service", metav1.GetOptions{})
-	if err != nil {
-		logger.Debug(fmt.Sprintf("Prometheus service in monitoring namespace is not available. %s", err.Error()))
-		return false
+	if err := waitForPrometheusReady(logger); err != nil {
+		logger.Error(err.Error() + ", rolling back the Prometheus configuration")
+		previous, rollbackErr := rollbackToPreviousConfigRevision(revision, keptnContext, logger)
+		if rollbackErr != nil {
+			return fmt.Errorf("%s; rollback failed: %s", err.Error(), rollbackErr.Error())
+		}
+		return fmt.Errorf("%s; rolled back to revision %d of the Prometheus configuration", err.Error(), previous)
 	}
-
-	logger.Debug("Prometheus service in monitoring namespace is available")
-	return true
+	return nil
 }
 
-func installPrometheus(logger keptn.LoggerInterface) error {
-	logger.Info("Installing Prometheus...")
-	prometheusHelper, err := utils.NewPrometheusHelper()
+func deletePrometheusPod() error {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+	// the pods are recreated by their deployment and load the updated config map
+	pods, err := api.CoreV1().Pods(promConfig.Namespace).List(metav1.ListOptions{LabelSelector: promConfig.Selector})
 	if err != nil {
-		logger.Debug(fmt.Sprintf("Could not initialize kubernetes client %s", err.Error()))
 		return err
 	}
+	for _, pod := range pods.Items {
+		if err := api.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// installPrometheus installs or upgrades the bundled Prometheus. fromVersion is the stack version of an existing
+// installation, whose configuration is migrated.
+func installPrometheus(prometheusHelper *utils.PrometheusHelper, fromVersion int, logger keptn.LoggerInterface) error {
+	logger.Info("Installing Prometheus...")
 	logger.Debug("Apply namespace for prometheus monitoring")
-	err = prometheusHelper.CreateOrUpdatePrometheusNamespace()
+	err := prometheusHelper.CreateOrUpdatePrometheusNamespace()
 	if err != nil {
 		return err
 	}
 
 	//config-map.yaml
 	logger.Debug("Apply config map for prometheus monitoring")
-	err = prometheusHelper.CreateOrUpdatePrometheusConfigMap()
+	err = prometheusHelper.CreateOrUpdatePrometheusConfigMap(fromVersion)
 	if err != nil {
 		return err
 	}
@@ -235,6 +287,12 @@ func installPrometheus(logger keptn.LoggerInterface) error {
 		return err
 	}
 
+	logger.Debug("Apply persistent volume claim for prometheus monitoring")
+	err = prometheusHelper.CreateOrUpdatePrometheusStorage()
+	if err != nil {
+		return err
+	}
+
 	//prometheus.yaml
 	logger.Debug("Apply service and deployment for prometheus monitoring")
 	err = prometheusHelper.CreateOrUpdatePrometheusDeployment()
@@ -247,12 +305,11 @@ func installPrometheus(logger keptn.LoggerInterface) error {
 	return nil
 }
 
-func installPrometheusAlertManager(logger keptn.LoggerInterface) error {
+func installPrometheusAlertManager(prometheusHelper *utils.PrometheusHelper, logger keptn.LoggerInterface) error {
 	logger.Info("Installing Prometheus AlertManager...")
-	prometheusHelper, err := utils.NewPrometheusHelper()
 	//alertmanager-configmap.yaml
 	logger.Debug("Apply configmap for prometheus alert manager")
-	err = prometheusHelper.CreateOrUpdateAlertManagerConfigMap()
+	err := prometheusHelper.CreateOrUpdateAlertManagerConfigMap()
 	if err != nil {
 		return err
 	}
@@ -283,208 +340,390 @@ func installPrometheusAlertManager(logger keptn.LoggerInterface) error {
 	return nil
 }
 
-func updatePrometheusConfigMap(eventData keptn.ConfigureMonitoringEventData, logger keptn.LoggerInterface, keptnHandler *keptn.Keptn) error {
-	shipyard, err := keptnHandler.GetShipyard()
+// managedAlertingGroup is a rule group whose keptn managed rules are generated from the SLOs of a service
+type managedAlertingGroup struct {
+	name  string
+	rules []*alertingRule
+}
+
+// stageMonitoringConfig holds the keptn managed scrape jobs and alerting rules of a service in one stage
+type stageMonitoringConfig struct {
+	project    string
+	stage      string
+	service    string
+	scrapeJobs []*scrapeJob
+	// alertingGroup is nil if no alerting rules are managed for this stage
+	alertingGroup *managedAlertingGroup
+}
+
+// monitoringConfig is the keptn managed part of the Prometheus configuration
+type monitoringConfig struct {
+	stages []*stageMonitoringConfig
+}
+
+// prometheusConfigMutex serializes the updates of the Prometheus config map within this process
+var prometheusConfigMutex sync.Mutex
+
+// updatePrometheusConfigMap applies the monitoring configuration and returns the number of the stored revision
+func updatePrometheusConfigMap(desiredConfig *monitoringConfig, keptnContext string, reason string, logger keptn.LoggerInterface) (int, error) {
+	promConfig, err := getTargetPrometheusConfig()
 	if err != nil {
-		return err
+		return 0, err
 	}
-
 	api, err := getKubeClient()
 	if err != nil {
+		return 0, err
+	}
+
+	prometheusConfigMutex.Lock()
+	defer prometheusConfigMutex.Unlock()
+
+	// the config map is re-read and the changes are re-applied if it has been modified in the meantime
+	var revision int
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cmPrometheus, err := api.CoreV1().ConfigMaps(promConfig.Namespace).Get(promConfig.ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := ensureInitialConfigRevision(api, cmPrometheus); err != nil {
+			return fmt.Errorf("could not store the initial revision of the Prometheus configuration: %s", err.Error())
+		}
+		if err := applyMonitoringConfig(cmPrometheus, desiredConfig, promConfig); err != nil {
+			return err
+		}
+		_, revision, err = updateConfigMapWithRevision(api, cmPrometheus, keptnContext, reason)
+		if apierrors.IsConflict(err) {
+			logger.Info("Prometheus config map has been modified concurrently, re-applying changes")
+		}
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
+	if err := pruneConfigRevisions(api); err != nil {
+		logger.Error(err.Error())
+	}
+	logger.Info(fmt.Sprintf("Stored revision %d of the Prometheus configuration", revision))
+	return revision, nil
+}
 
-	cmPrometheus, err := api.CoreV1().ConfigMaps("monitoring").Get("prometheus-server-conf", metav1.GetOptions{})
+// applyMonitoringConfig writes the keptn managed scrape jobs and alerting rules into the Prometheus config map
+func applyMonitoringConfig(cmPrometheus *v1.ConfigMap, desiredConfig *monitoringConfig, promConfig *utils.PrometheusConfig) error {
+	if cmPrometheus.Data == nil {
+		cmPrometheus.Data = map[string]string{}
+	}
+
+	// check if alerting rules are already available; a rule file that cannot be parsed is never overwritten
+	alertingRulesConfig, err := parseAlertingRules(cmPrometheus.Data[promConfig.RulesFileKey])
 	if err != nil {
 		return err
 	}
-	config, err := prometheusconfig.Load(cmPrometheus.Data["prometheus.yml"])
+	var scrapeJobs []*scrapeJob
+	for _, stageConfig := range desiredConfig.stages {
+		scrapeJobs = append(scrapeJobs, stageConfig.scrapeJobs...)
+		if stageConfig.alertingGroup != nil {
+			mergeAlertingGroup(alertingRulesConfig, stageConfig.alertingGroup.name, stageConfig.alertingGroup.rules)
+		}
+	}
+	alertingRulesYAMLString, err := yaml.Marshal(alertingRulesConfig)
 	if err != nil {
 		return err
 	}
-	fmt.Println(config)
 
-	// check if alerting rules are already availablre
-	var alertingRulesConfig alertingRules
-	if cmPrometheus.Data["prometheus.rules"] != "" {
-		yaml.Unmarshal([]byte(cmPrometheus.Data["prometheus.rules"]), &alertingRulesConfig)
-	} else {
-		alertingRulesConfig = alertingRules{}
+	// only the keptn scrape jobs are rewritten, everything else in prometheus.yml stays untouched
+	prometheusYml, err := updateScrapeConfigs(cmPrometheus.Data[promConfig.ConfigFileKey], scrapeJobs)
+	if err != nil {
+		return err
 	}
-	// update
-	for _, stage := range shipyard.Stages {
-		var scrapeConfig *prometheusconfig.ScrapeConfig
-		// (a) if a scrape config with the same name is available, update that one
 
-		if stage.DeploymentStrategy == "blue_green_service" {
-			createScrapeJobConfig(scrapeConfig, config, eventData.Project, stage.Name, eventData.Service, false, true)
-			createScrapeJobConfig(scrapeConfig, config, eventData.Project, stage.Name, eventData.Service, true, false)
-		} else {
-			createScrapeJobConfig(scrapeConfig, config, eventData.Project, stage.Name, eventData.Service, false, false)
-		}
+	cmPrometheus.Data[promConfig.RulesFileKey] = string(alertingRulesYAMLString)
+	cmPrometheus.Data[promConfig.ConfigFileKey] = prometheusYml
+	return nil
+}
 
-		// only create alerts for stages that use auto-remediation
-		if stage.RemediationStrategy != "automated" {
-			continue
-		}
+// getMonitoringConfig creates the scrape jobs and alerting rules of the selected services for all stages of their
+// project
+func getMonitoringConfig(eventData keptn.ConfigureMonitoringEventData, options *configureOptions, logger keptn.LoggerInterface, keptnHandler *keptn.Keptn) (*monitoringConfig, error) {
+	shipyard, err := keptnHandler.GetShipyard()
+	if err != nil {
+		return nil, err
+	}
+	config, err := utils.GetConfig()
+	if err != nil {
+		return nil, err
+	}
 
-		slos, err := retrieveSLOs(eventData, stage.Name, logger)
-		if err != nil || slos == nil {
-			logger.Info("No SLO file found for stage " + stage.Name + ". No alerting rules created for this stage")
-			continue
+	desiredConfig := &monitoringConfig{}
+	for _, stage := range shipyard.Stages {
+		services, err := getServicesToConfigure(eventData, options, stage.Name)
+		if err != nil {
+			return nil, err
 		}
+		for _, service := range services {
+			stageConfig := &stageMonitoringConfig{
+				project: eventData.Project,
+				stage:   stage.Name,
+				service: service,
+			}
+			desiredConfig.stages = append(desiredConfig.stages, stageConfig)
 
-		// Create or update alerting group
-		var alertingGroupConfig *alertingGroup
-		alertingGroupName := eventData.Service + " " + eventData.Project + "-" + stage.Name + " alerts"
-		alertingGroupConfig = getAlertingGroup(&alertingRulesConfig, alertingGroupName)
-		if alertingGroupConfig == nil {
-			alertingGroupConfig = &alertingGroup{
-				Name: alertingGroupName,
+			if stage.DeploymentStrategy == "blue_green_service" {
+				stageConfig.scrapeJobs = append(stageConfig.scrapeJobs, createScrapeJobConfig(eventData.Project, stage.Name, service, false, true))
+				stageConfig.scrapeJobs = append(stageConfig.scrapeJobs, createScrapeJobConfig(eventData.Project, stage.Name, service, true, false))
+			} else {
+				stageConfig.scrapeJobs = append(stageConfig.scrapeJobs, createScrapeJobConfig(eventData.Project, stage.Name, service, false, false))
+			}
+
+			sliConfig, err := getSLIConfiguration(config, eventData.Project, stage.Name, service, logger)
+			if err != nil {
+				// the rules are not rebuilt with the default queries while the custom ones cannot be read
+				return nil, err
 			}
-			alertingRulesConfig.Groups = append(alertingRulesConfig.Groups, alertingGroupConfig)
-		}
 
-		for _, objective := range slos.Objectives {
+			// Create or update alerting group
+			alertingGroupName := getAlertingGroupName(eventData.Project, stage.Name, service)
+			var generatedRules []*alertingRule
 
-			expr, err := getSLIQuery(eventData.Project, stage.Name, eventData.Service, objective.SLI, slos.Filter, logger)
-			if err != nil || expr == "" {
-				logger.Error("No query defined for SLI " + objective.SLI + " in project " + eventData.Project)
+			if config.HealthAlerts {
+				healthRules, err := getHealthAlertingRules(config, sliConfig, eventData.Project, stage.Name, service, stageConfig.scrapeJobs)
+				if err != nil {
+					logger.Error(fmt.Sprintf("Could not create health alerts of service %s in stage %s: %s", service, stage.Name, err.Error()))
+				}
+				generatedRules = append(generatedRules, healthRules...)
+				stageConfig.alertingGroup = &managedAlertingGroup{
+					name:  alertingGroupName,
+					rules: generatedRules,
+				}
+			}
+
+			// only create SLO alerts for stages that use auto-remediation
+			if stage.RemediationStrategy != "automated" {
 				continue
 			}
 
-			if objective.Pass != nil {
-				for _, criteriaGroup := range objective.Pass {
-					for _, criteria := range criteriaGroup.Criteria {
-						if strings.Contains(criteria, "+") || strings.Contains(criteria, "-") || strings.Contains(criteria, "%") || (!strings.Contains(criteria, "<") && !strings.Contains(criteria, ">")) {
-							continue
-						}
-						criteriaString := strings.Replace(criteria, "=", "", -1)
-						if strings.Contains(criteriaString, "<") {
-							criteriaString = strings.Replace(criteriaString, "<", ">", -1)
-						} else {
-							criteriaString = strings.Replace(criteriaString, ">", "<", -1)
-						}
+			slos, predictions, err := retrieveSLOs(eventData.Project, stage.Name, service, logger)
+			if err != nil {
+				// the rules are not rebuilt without the SLOs, otherwise an unavailable configuration-service would
+				// remove them
+				return nil, err
+			}
+			if slos == nil {
+				logger.Info("No SLO file found for stage " + stage.Name + ". No SLO alerting rules created for this stage")
+				continue
+			}
+
+			for _, objective := range slos.Objectives {
 
-						var newAlertingRule *alertingRule
-						ruleName := objective.SLI
-						newAlertingRule = getAlertingRuleOfGroup(alertingGroupConfig, ruleName)
-						if newAlertingRule == nil {
-							newAlertingRule = &alertingRule{
-								Alert: ruleName,
+				expr, err := getSLIQuery(eventData.Project, stage.Name, service, stage.DeploymentStrategy, objective.SLI, slos.Filter, sliConfig, logger)
+				if err != nil {
+					logger.Error(fmt.Sprintf("Could not create query for SLI %s of service %s in stage %s: %s", objective.SLI, service, stage.Name, err.Error()))
+					continue
+				}
+				if expr == "" {
+					logger.Error("No query defined for SLI " + objective.SLI + " in project " + eventData.Project)
+					continue
+				}
+
+				if objective.Pass != nil {
+					for _, criteriaGroup := range objective.Pass {
+						for _, criteria := range criteriaGroup.Criteria {
+							anomaly, ok, err := parseAnomalyCriterion(criteria)
+							if err != nil {
+								logger.Error(fmt.Sprintf("Could not create alert for SLI %s of service %s in stage %s: %s", objective.SLI, service, stage.Name, err.Error()))
+								continue
+							} else if ok {
+								anomalyRules, err := getAnomalyRules(config, eventData.Project, stage.Name, service, objective.SLI, expr, anomaly)
+								if err != nil {
+									logger.Error(fmt.Sprintf("Could not create alert for SLI %s of service %s in stage %s: %s", objective.SLI, service, stage.Name, err.Error()))
+									continue
+								}
+								for _, rule := range anomalyRules {
+									if getAlertingRule(generatedRules, rule.key()) == nil {
+										generatedRules = append(generatedRules, rule)
+									}
+								}
+								continue
+							}
+							if strings.Contains(criteria, "+") || strings.Contains(criteria, "-") || strings.Contains(criteria, "%") || (!strings.Contains(criteria, "<") && !strings.Contains(criteria, ">")) {
+								continue
+							}
+							criteriaString := strings.Replace(criteria, "=", "", -1)
+							if strings.Contains(criteriaString, "<") {
+								criteriaString = strings.Replace(criteriaString, "<", ">", -1)
+							} else {
+								criteriaString = strings.Replace(criteriaString, ">", "<", -1)
+							}
+
+							ruleName := objective.SLI
+							newAlertingRule := getAlertingRule(generatedRules, "alert:"+ruleName)
+							if newAlertingRule == nil {
+								newAlertingRule = &alertingRule{
+									Alert: ruleName,
+								}
+								generatedRules = append(generatedRules, newAlertingRule)
+							}
+							newAlertingRule.Expr = expr + criteriaString
+							newAlertingRule.For = config.AlertDuration
+							newAlertingRule.Labels = getAlertLabels(eventData.Project, stage.Name, service)
+							newAlertingRule.Annotations = map[string]string{
+								"summary":      ruleName,
+								"descriptions": "Pod name {{ $labels.pod_name }}",
+							}
+							if options, ok := predictions[objective.SLI]; ok {
+								predictive, annotations, err := getPredictiveExpr(objective.SLI, expr, criteriaString, options)
+								if err != nil {
+									// the threshold alert would fire later than requested
+									return nil, fmt.Errorf("could not create predictive alert for SLI %s of service %s in stage %s: %s", objective.SLI, service, stage.Name, err.Error())
+								}
+								newAlertingRule.Expr = predictive
+								newAlertingRule.Annotations = annotations
 							}
-							alertingGroupConfig.Rules = append(alertingGroupConfig.Rules, newAlertingRule)
-						}
-						newAlertingRule.Alert = ruleName
-						newAlertingRule.Expr = expr + criteriaString
-						newAlertingRule.For = "10m" // TODO: introduce alert duration concept in SLO?
-						newAlertingRule.Labels = &alertingLabel{
-							Severity: "webhook",
-							PodName:  eventData.Service + "-primary",
-							Service:  eventData.Service,
-							Project:  eventData.Project,
-							Stage:    stage.Name,
-						}
-						newAlertingRule.Annotations = &alertingAnnotations{
-							Summary:     ruleName,
-							Description: "Pod name {{ $labels.pod_name }}",
 						}
 					}
 				}
 			}
+			stageConfig.alertingGroup = &managedAlertingGroup{
+				name:  alertingGroupName,
+				rules: generatedRules,
+			}
 		}
 	}
-	alertingRulesYAMLString, err := yaml.Marshal(alertingRulesConfig)
-	if err != nil {
-		return err
-	}
-	// apply
-	cmPrometheus.Data["prometheus.rules"] = string(alertingRulesYAMLString)
-	cmPrometheus.Data["prometheus.yml"] = config.String()
-	_, err = api.CoreV1().ConfigMaps("monitoring").Update(cmPrometheus)
-	if err != nil {
-		return err
+	return desiredConfig, nil
+}
+
+// getAlertLabels returns the labels of the generated alerts, which route them to the keptn webhook and identify the
+// service of the problem
+func getAlertLabels(project string, stage string, service string) map[string]string {
+	return map[string]string{
+		"severity": "webhook",
+		"pod_name": service + "-primary",
+		"service":  service,
+		"project":  project,
+		"stage":    stage,
 	}
-	return nil
 }
 
-func getKubeClient() (*kubernetes.Clientset, error) {
-	k8sConfig, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
+// getServicesToConfigure returns the services of a stage that are configured by the event. These are the service of
+// the event and the services given in the options, or all services of the stage if neither is set.
+func getServicesToConfigure(eventData keptn.ConfigureMonitoringEventData, options *configureOptions, stage string) ([]string, error) {
+	var services []string
+	for _, service := range append([]string{eventData.Service}, options.Services...) {
+		if service != "" && !containsString(services, service) {
+			services = append(services, service)
+		}
 	}
-	api, err := kubernetes.NewForConfig(k8sConfig)
+	if len(services) > 0 {
+		return services, nil
+	}
+
+	serviceHandler := configutils.NewServiceHandler(getConfigurationServiceURL())
+	stageServices, err := serviceHandler.GetAllServices(eventData.Project, stage)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not retrieve the services of project %s in stage %s: %s", eventData.Project, stage, err.Error())
 	}
-	return api, nil
-}
-
-func getDefaultFilterExpression(project string, stage string, service string, filters map[string]string) string {
-	filterExpression := "job='" + service + "-" + project + "-" + stage + "'"
-	if filters != nil && len(filters) > 0 {
-		for key, value := range filters {
-			/* if no operator has been included in the label filter, use exact matching (=), e.g.
-			e.g.:
-			key: handler
-			value: ItemsController
-			*/
-			sanitizedValue := value
-			if !strings.HasPrefix(sanitizedValue, "=") && !strings.HasPrefix(sanitizedValue, "!=") && !strings.HasPrefix(sanitizedValue, "=~") && !strings.HasPrefix(sanitizedValue, "!~") {
-				sanitizedValue = strings.Replace(sanitizedValue, "'", "", -1)
-				sanitizedValue = strings.Replace(sanitizedValue, "\"", "", -1)
-				filterExpression = filterExpression + "," + key + "='" + sanitizedValue + "'"
-			} else {
-				/* if a valid operator (=, !=, =~, !~) is prepended to the value, use that one
-				e.g.:
-				key: handler
-				value: !=HealthCheckController
-
-				OR
-
-				key: handler
-				value: =~.+ItemsController|.+VersionController
-				*/
-				sanitizedValue = strings.Replace(sanitizedValue, "\"", "'", -1)
-				filterExpression = filterExpression + "," + key + sanitizedValue
-			}
+	for _, service := range stageServices {
+		services = append(services, service.ServiceName)
+	}
+	return services, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
 		}
 	}
-	return filterExpression
+	return false
 }
 
-func getSLIQuery(project string, stage string, service string, sli string, filters map[string]string, logger keptn.LoggerInterface) (string, error) {
-	query, err := getCustomQuery(project, sli, logger)
-	if err == nil && query != "" {
-		query = replaceQueryParameters(query, project, stage, service, filters)
+// services returns the names of the configured services in the order they appear
+func (c *monitoringConfig) services() []string {
+	var services []string
+	for _, stage := range c.stages {
+		if !containsString(services, stage.service) {
+			services = append(services, stage.service)
+		}
+	}
+	return services
+}
+
+func getKubeClient() (kubernetes.Interface, error) {
+	return utils.GetKubeClient()
+}
 
+func getSLIQuery(project string, stage string, service string, deploymentType string, sli string, filters map[string]string, sliConfig *sliConfiguration, logger keptn.LoggerInterface) (string, error) {
+	config, err := utils.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	if custom, ok := sliConfig.queries[sli]; ok {
+		logger.Info("Using custom query for SLI " + sli + " from " + custom.source)
+		data, err := newQueryTemplateData(config, project, stage, service, deploymentType, filters)
+		if err != nil {
+			return "", err
+		}
+		query, err := renderQuery(custom.query, data)
+		if err != nil {
+			return "", fmt.Errorf("%s of %s", err.Error(), custom.source)
+		}
 		return query, nil
 	}
+	profile, err := getMetricProfile(config, sliConfig.profile)
+	if err != nil {
+		return "", fmt.Errorf("%s of %s", err.Error(), sliConfig.profileSource)
+	}
+	var query string
 	switch sli {
 	case Throughput:
-		logger.Info("Using default query for throughput")
-		query = getDefaultThroughputQuery(project, stage, service, filters)
+		logger.Info("Using default query for throughput of metric profile " + sliConfig.profile)
+		query, err = getDefaultThroughputQuery(config, profile, project, stage, service, filters)
 	case ErrorRate:
-		logger.Info("Using default query for error_rate")
-		query = getDefaultErrorRateQuery(project, stage, service, filters)
+		logger.Info("Using default query for error_rate of metric profile " + sliConfig.profile)
+		query, err = getDefaultErrorRateQuery(config, profile, project, stage, service, filters)
 	case ResponseTimeP50:
-		logger.Info("Using default query for response_time_p50")
-		query = getDefaultResponseTimeQuery(project, stage, service, filters, "50")
+		logger.Info("Using default query for response_time_p50 of metric profile " + sliConfig.profile)
+		query, err = getDefaultResponseTimeQuery(config, profile, project, stage, service, filters, "50")
 	case ResponseTimeP90:
-		logger.Info("Using default query for response_time_p90")
-		query = getDefaultResponseTimeQuery(project, stage, service, filters, "90")
+		logger.Info("Using default query for response_time_p90 of metric profile " + sliConfig.profile)
+		query, err = getDefaultResponseTimeQuery(config, profile, project, stage, service, filters, "90")
 	case ResponseTimeP95:
-		logger.Info("Using default query for response_time_p95")
-		query = getDefaultResponseTimeQuery(project, stage, service, filters, "95")
+		logger.Info("Using default query for response_time_p95 of metric profile " + sliConfig.profile)
+		query, err = getDefaultResponseTimeQuery(config, profile, project, stage, service, filters, "95")
+	case ResponseTimeP99:
+		logger.Info("Using default query for response_time_p99 of metric profile " + sliConfig.profile)
+		query, err = getDefaultResponseTimeQuery(config, profile, project, stage, service, filters, "99")
+	case Availability:
+		logger.Info("Using default query for availability of metric profile " + sliConfig.profile)
+		query, err = getDefaultAvailabilityQuery(config, profile, project, stage, service, filters)
+	case Apdex:
+		logger.Info("Using default query for apdex of metric profile " + sliConfig.profile)
+		query, err = getDefaultApdexQuery(config, profile, project, stage, service, filters)
+	case CPUSaturation:
+		logger.Info("Using default query for cpu_saturation")
+		query, err = getCPUSaturationQuery(config, project, stage, service, deploymentType)
+	case MemorySaturation:
+		logger.Info("Using default query for memory_saturation")
+		query, err = getMemorySaturationQuery(project, stage, service, deploymentType)
+	case PodRestarts:
+		logger.Info("Using default query for pod_restarts")
+		query, err = getPodRestartsQuery(config, project, stage, service, deploymentType)
+	case ReadyReplicaRatio:
+		logger.Info("Using default query for ready_replica_ratio")
+		query, err = getReadyReplicaRatioQuery(project, stage, service, deploymentType)
 	default:
 		return "", errors.New("unsupported SLI")
 	}
-	query = replaceQueryParameters(query, project, stage, service, filters)
+	if err != nil {
+		return "", err
+	}
 	return query, nil
 }
 
-func getDefaultThroughputQuery(project string, stage string, service string, filters map[string]string) string {
-	filterExpr := getDefaultFilterExpression(project, stage, service, filters)
+func getDefaultThroughputQuery(config *utils.ServiceConfig, profile *metricProfile, project string, stage string, service string, filters map[string]string) (string, error) {
+	selector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), filters)
+	if err != nil {
+		return "", err
+	}
 	// e.g. sum(rate(http_requests_total{job="carts-sockshop-dev"}[30m]))&time=1571649085
 	/*
 		{
@@ -503,11 +742,18 @@ func getDefaultThroughputQuery(project string, stage string, service string, fil
 		    }
 		}
 	*/
-	return "sum(rate(http_requests_total{" + filterExpr + "}[180s]))"
+	return "sum(rate(" + selector + "[" + config.QueryRange + "]))", nil
 }
 
-func getDefaultErrorRateQuery(project string, stage string, service string, filters map[string]string) string {
-	filterExpr := getDefaultFilterExpression(project, stage, service, filters)
+func getDefaultErrorRateQuery(config *utils.ServiceConfig, profile *metricProfile, project string, stage string, service string, filters map[string]string) (string, error) {
+	selector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), filters)
+	if err != nil {
+		return "", err
+	}
+	errorSelector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), filters, profile.errorMatcher)
+	if err != nil {
+		return "", err
+	}
 	// e.g. sum(rate(http_requests_total{job="carts-sockshop-dev",status!~'2..'}[30m]))/sum(rate(http_requests_total{job="carts-sockshop-dev"}[30m]))&time=1571649085
 	/*
 		with value:
@@ -536,11 +782,14 @@ func getDefaultErrorRateQuery(project string, stage string, service string, filt
 		    }
 		}
 	*/
-	return "sum(rate(http_requests_total{" + filterExpr + ",status!~'2..'}[180s]))/sum(rate(http_requests_total{" + filterExpr + "}[180s]))"
+	return "sum(rate(" + errorSelector + "[" + config.QueryRange + "]))/sum(rate(" + selector + "[" + config.QueryRange + "]))", nil
 }
 
-func getDefaultResponseTimeQuery(project string, stage string, service string, filters map[string]string, percentile string) string {
-	filterExpr := getDefaultFilterExpression(project, stage, service, filters)
+func getDefaultResponseTimeQuery(config *utils.ServiceConfig, profile *metricProfile, project string, stage string, service string, filters map[string]string, percentile string) (string, error) {
+	selector, err := getSelector(profile.durationBucket, profile.serviceMatchers(project, stage, service), filters)
+	if err != nil {
+		return "", err
+	}
 	// e.g. histogram_quantile(0.95, sum(rate(http_response_time_milliseconds_bucket{job='carts-sockshop-dev'}[30m])) by (le))&time=1571649085
 	/*
 		{
@@ -559,73 +808,51 @@ func getDefaultResponseTimeQuery(project string, stage string, service string, f
 		    }
 		}
 	*/
-	return "histogram_quantile(0." + percentile + ",sum(rate(http_response_time_milliseconds_bucket{" + filterExpr + "}[180s]))by(le))"
-}
-
-func replaceQueryParameters(query string, project string, stage string, service string, filters map[string]string) string {
-	for key, value := range filters {
-		sanitizedValue := value
-		sanitizedValue = strings.Replace(sanitizedValue, "'", "", -1)
-		sanitizedValue = strings.Replace(sanitizedValue, "\"", "", -1)
-		query = strings.Replace(query, "$"+key, sanitizedValue, -1)
-		query = strings.Replace(query, "$"+strings.ToUpper(key), sanitizedValue, -1)
+	query := "histogram_quantile(0." + percentile + ",sum(rate(" + selector + "[" + config.QueryRange + "]))by(le))"
+	if factor := profile.durationFactor(); factor != "" {
+		query = query + "*" + factor
 	}
-	query = strings.Replace(query, "$PROJECT", project, -1)
-	query = strings.Replace(query, "$STAGE", stage, -1)
-	query = strings.Replace(query, "$SERVICE", service, -1)
-	query = strings.Replace(query, "$project", project, -1)
-	query = strings.Replace(query, "$stage", stage, -1)
-	query = strings.Replace(query, "$service", service, -1)
-	query = strings.Replace(query, "$DURATION_SECONDS", "180s", -1)
-	return query
+	return query, nil
 }
 
-func getCustomQuery(project string, sli string, logger keptn.LoggerInterface) (string, error) {
-	kubeClient, err := getKubeClient()
+func getDefaultAvailabilityQuery(config *utils.ServiceConfig, profile *metricProfile, project string, stage string, service string, filters map[string]string) (string, error) {
+	selector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), filters)
 	if err != nil {
-		logger.Error("could not create kube client")
-		return "", errors.New("could not create kube client")
+		return "", err
 	}
-	logger.Info("Checking for custom SLI queries for project " + project)
-
-	// try to get project-specific configMap
-	configMap, err := kubeClient.CoreV1().ConfigMaps("keptn").Get(keptnPrometheusSLIConfigMapName+"-"+project, metav1.GetOptions{})
-
-	if err == nil {
-		query, err := extractCustomQueryFromCM(configMap, logger, sli, project)
-		if err == nil && query != "" {
-			return query, nil
-		}
+	successMatcher, err := profile.serverErrorMatcher.Inverse()
+	if err != nil {
+		return "", err
 	}
-
-	// if no config Map could be found, try to get the global one
-	configMap, err = kubeClient.CoreV1().ConfigMaps("keptn").Get(keptnPrometheusSLIConfigMapName, metav1.GetOptions{})
-
-	query, err := extractCustomQueryFromCM(configMap, logger, sli, project)
+	successSelector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), filters, successMatcher)
 	if err != nil {
 		return "", err
 	}
-
-	return query, nil
-
+	// e.g. sum(rate(http_requests_total{job="carts-sockshop-dev",status!~"5.."}[30m]))/sum(rate(http_requests_total{job="carts-sockshop-dev"}[30m]))
+	return "sum(rate(" + successSelector + "[" + config.QueryRange + "]))/sum(rate(" + selector + "[" + config.QueryRange + "]))", nil
 }
 
-func extractCustomQueryFromCM(configMap *v1.ConfigMap, logger keptn.LoggerInterface, sli string, project string) (string, error) {
-	if configMap == nil || configMap.Data == nil || configMap.Data["custom-queries"] == "" {
-		logger.Info("No custom query defined for SLI " + sli + " in project " + project)
-		return "", nil
+func getDefaultApdexQuery(config *utils.ServiceConfig, profile *metricProfile, project string, stage string, service string, filters map[string]string) (string, error) {
+	serviceMatchers := profile.serviceMatchers(project, stage, service)
+	satisfied, err := getSelector(profile.durationBucket, serviceMatchers, filters, profile.bucketMatcher(config.ApdexThreshold))
+	if err != nil {
+		return "", err
 	}
-	customQueries := make(map[string]string)
-	err := yaml.Unmarshal([]byte(configMap.Data["custom-queries"]), &customQueries)
-	if err != nil || customQueries == nil || customQueries[sli] == "" {
-		logger.Info("No custom query defined for SLI " + sli + " in project " + project)
-		return "", nil
+	tolerating, err := getSelector(profile.durationBucket, serviceMatchers, filters, profile.bucketMatcher(4*config.ApdexThreshold))
+	if err != nil {
+		return "", err
 	}
-	query := customQueries[sli]
-	return query, nil
+	total, err := getSelector(profile.durationCountMetric(), serviceMatchers, filters)
+	if err != nil {
+		return "", err
+	}
+	// the tolerating bucket also holds the satisfied requests, which are thereby counted fully
+	// e.g. (sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev",le=~"500(\.0+)?"}[30m]))+sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev",le=~"2000(\.0+)?"}[30m])))/2/sum(rate(http_response_time_milliseconds_count{job="carts-sockshop-dev"}[30m]))
+	return "(sum(rate(" + satisfied + "[" + config.QueryRange + "]))+sum(rate(" + tolerating + "[" + config.QueryRange + "])))/2/sum(rate(" + total + "[" + config.QueryRange + "]))", nil
 }
 
-func createScrapeJobConfig(scrapeConfig *prometheusconfig.ScrapeConfig, config *prometheusconfig.Config, project string, stage string, service string, isCanary bool, isPrimary bool) {
+// createScrapeJobConfig creates the scrape job for a service in the given stage
+func createScrapeJobConfig(project string, stage string, service string, isCanary bool, isPrimary bool) *scrapeJob {
 	scrapeConfigName := service + "-" + project + "-" + stage
 	var scrapeEndpoint string
 	if isCanary {
@@ -637,82 +864,72 @@ func createScrapeJobConfig(scrapeConfig *prometheusconfig.ScrapeConfig, config *
 		scrapeEndpoint = service + "." + project + "-" + stage + ":80"
 	}
 
-	scrapeConfig = getScrapeConfig(config, scrapeConfigName)
-	// (b) if not, create a new scrape config
-	if scrapeConfig == nil {
-		scrapeConfig = &prometheusconfig.ScrapeConfig{}
-		config.ScrapeConfigs = append(config.ScrapeConfigs, scrapeConfig)
-	}
-	scrapeConfig.JobName = scrapeConfigName
-	scrapeConfig.MetricsPath = "/prometheus"
-	scrapeConfig.ServiceDiscoveryConfig = prometheus_sd_config.ServiceDiscoveryConfig{
-		StaticConfigs: []*targetgroup.Group{
+	return &scrapeJob{
+		JobName:     scrapeConfigName,
+		MetricsPath: "/prometheus",
+		StaticConfigs: []*staticConfig{
 			{
-				Targets: []prometheus_model.LabelSet{
-					{prometheus_model.AddressLabel: prometheus_model.LabelValue(scrapeEndpoint)},
-				},
+				Targets: []string{scrapeEndpoint},
 			},
 		},
 	}
 }
 
-func getAlertingRuleOfGroup(alertingGroup *alertingGroup, alertName string) *alertingRule {
-	for _, rule := range alertingGroup.Rules {
-		if rule.Alert == alertName {
-			return rule
-		}
-	}
-	return nil
-}
-
-func getAlertingGroup(alertingRulesConfig *alertingRules, groupName string) *alertingGroup {
-	for _, alertingGroup := range alertingRulesConfig.Groups {
-		if alertingGroup.Name == groupName {
-			return alertingGroup
-		}
-	}
-	return nil
-}
-
-func getScrapeConfig(config *prometheusconfig.Config, name string) *prometheusconfig.ScrapeConfig {
-	for _, scrapeConfig := range config.ScrapeConfigs {
-		if scrapeConfig.JobName == name {
-			return scrapeConfig
-		}
-	}
-	return nil
-}
-
+// getConfigurationServiceURL returns the address of the configuration-service given by CONFIGURATION_SERVICE
 func getConfigurationServiceURL() string {
-	if os.Getenv("env") == "production" {
-		return "configuration-service.keptn.svc.cluster.local:8080"
+	config, err := utils.GetConfig()
+	if err != nil {
+		return ""
 	}
-	return "localhost:6060"
+	return config.ConfigurationServiceURL
 }
 
-func retrieveSLOs(eventData keptn.ConfigureMonitoringEventData, stage string, logger keptn.LoggerInterface) (*keptn.ServiceLevelObjectives, error) {
+// retrieveSLOs returns the SLO file of a service in a stage together with the predict options of its objectives, or
+// nil if the service has no SLO file. An error is returned if the SLO file could not be retrieved or is invalid.
+func retrieveSLOs(project string, stage string, service string, logger keptn.LoggerInterface) (*keptn.ServiceLevelObjectives, map[string]*predictOptions, error) {
 	resourceHandler := configutils.NewResourceHandler(getConfigurationServiceURL())
 
-	resource, err := resourceHandler.GetServiceResource(eventData.Project, stage, eventData.Service, "slo.yaml")
-	if err != nil || resource.ResourceContent == "" {
-		return nil, errors.New("No SLO file available for service " + eventData.Service + " in stage " + stage)
+	resource, err := resourceHandler.GetServiceResource(project, stage, service, "slo.yaml")
+	if err == configutils.ResourceNotFoundError {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("could not retrieve the SLO file of service %s in stage %s: %s", service, stage, err.Error())
+	}
+	if resource == nil || resource.ResourceContent == "" {
+		return nil, nil, nil
 	}
 	var slos keptn.ServiceLevelObjectives
 
 	err = yaml.Unmarshal([]byte(resource.ResourceContent), &slos)
 
 	if err != nil {
-		return nil, errors.New("Invalid SLO file format")
+		return nil, nil, errors.New("Invalid SLO file format of service " + service + " in stage " + stage)
 	}
 
-	return &slos, nil
+	predictions, err := getPredictOptions(resource.ResourceContent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid SLO file format of service %s in stage %s: %s", service, stage, err.Error())
+	}
+
+	return &slos, predictions, nil
 }
 
 // logErrAndRespondWithDoneEvent sends a keptn done event to the keptn eventbroker
-func logErrAndRespondWithDoneEvent(event cloudevents.Event, version *models.Version, err error, logger keptn.LoggerInterface) error {
+func logErrAndRespondWithDoneEvent(event cloudevents.Event, configureResult *configureResult, err error, logger keptn.LoggerInterface) error {
 	var result = "success"
 	//var webSocketMessage = "Prometheus successfully configured"
 	var eventMessage = "Prometheus successfully configured and rule created"
+	if configureResult != nil && configureResult.installation != nil {
+		eventMessage = fmt.Sprintf("%s (%s)", eventMessage, configureResult.installation.String())
+	}
+	if configureResult != nil {
+		if summary := configureResult.verification.summary(); summary != "" {
+			eventMessage = fmt.Sprintf("%s, but %s", eventMessage, summary)
+		}
+		if configureResult.plan != nil {
+			eventMessage = "Dry run, no changes applied: " + configureResult.plan.summary()
+		}
+	}
 
 	if err != nil { // error
 		result = "error"
@@ -726,7 +943,7 @@ func logErrAndRespondWithDoneEvent(event cloudevents.Event, version *models.Vers
 	// if err := websocketutil.WriteWSLog(ws, createEventCopy(event, "sh.keptn.events.log"), webSocketMessage, true, "INFO"); err != nil {
 	// 	logger.Error(fmt.Sprintf("Could not write log to websocket. %s", err.Error()))
 	// }
-	if err := sendDoneEvent(event, result, eventMessage, version); err != nil {
+	if err := sendDoneEvent(event, result, eventMessage, configureResult); err != nil {
 		logger.Error(fmt.Sprintf("No sh.keptn.event.done event sent. %s", err.Error()))
 	}
 
@@ -770,7 +987,7 @@ func createEventCopy(eventSource cloudevents.Event, eventType string) cloudevent
 }
 
 // sendDoneEvent prepares a keptn done event and sends it to the eventbroker
-func sendDoneEvent(receivedEvent cloudevents.Event, result string, message string, version *models.Version) error {
+func sendDoneEvent(receivedEvent cloudevents.Event, result string, message string, configureResult *configureResult) error {
 
 	doneEvent := createEventCopy(receivedEvent, "sh.keptn.events.done")
 
@@ -779,13 +996,25 @@ func sendDoneEvent(receivedEvent cloudevents.Event, result string, message strin
 		Message: message,
 	}
 
-	if version != nil {
-		eventData.Version = version.Version
+	if configureResult != nil {
+		if len(configureResult.versions) > 0 {
+			eventData.Version = configureResult.versions[len(configureResult.versions)-1].Version
+		}
+		eventData.Versions = configureResult.versions
+		eventData.Installation = configureResult.installation
+		eventData.Services = configureResult.services
+		eventData.Health = configureResult.health
+		eventData.Verification = configureResult.verification
+		eventData.Plan = configureResult.plan
 	}
 
 	doneEvent.Data = eventData
 
-	endPoint, err := utils.GetServiceEndpoint(eventbroker)
+	config, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	endPoint, err := utils.GetServiceEndpoint(config.EventBrokerURL)
 	if err != nil {
 		return errors.New("Failed to retrieve endpoint of eventbroker. %s" + err.Error())
 	}
@@ -0,0 +1,188 @@
+package eventhandling
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// configFileFlag is the Prometheus flag giving the path of prometheus.yml
+const configFileFlag = "--config.file"
+
+// resolvePrometheusInstallation determines the config map mounted by the Prometheus pods of a detected installation.
+// An error is returned if the installation cannot be configured, e.g. a Prometheus Operator instance, whose
+// configuration consists of PrometheusRule and ServiceMonitor objects.
+func resolvePrometheusInstallation(api kubernetes.Interface, installation *prometheusInstallation) error {
+	switch installation.Kind {
+	case installationKindService, installationKindLabels:
+		return resolveConfigMap(api, installation)
+	case installationKindOperator:
+		return notConfigurableError(installation, "the configuration of a Prometheus Operator instance consists of PrometheusRule and ServiceMonitor objects, which are not written by the prometheus-service")
+	}
+	return nil
+}
+
+func notConfigurableError(installation *prometheusInstallation, format string, args ...interface{}) error {
+	return fmt.Errorf("%s has been detected but is not configurable: %s", installation.String(), fmt.Sprintf(format, args...))
+}
+
+// resolveConfigMap finds the config map holding prometheus.yml and a rule file loaded by it in the pods of the
+// Prometheus service
+func resolveConfigMap(api kubernetes.Interface, installation *prometheusInstallation) error {
+	service, err := api.CoreV1().Services(installation.Namespace).Get(installation.Name, metav1.GetOptions{})
+	if err != nil {
+		return notConfigurableError(installation, "could not read the service: %s", err.Error())
+	}
+	if len(service.Spec.Selector) == 0 {
+		return notConfigurableError(installation, "the service does not select any pods")
+	}
+	selector := k8slabels.SelectorFromSet(service.Spec.Selector).String()
+	pods, err := api.CoreV1().Pods(installation.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return notConfigurableError(installation, "could not list the pods %s: %s", selector, err.Error())
+	}
+	if len(pods.Items) == 0 {
+		return notConfigurableError(installation, "no pods match %s", selector)
+	}
+	pod := pods.Items[0]
+
+	var container *v1.Container
+	var configPath string
+	for i := range pod.Spec.Containers {
+		if configPath = getConfigFileFlag(&pod.Spec.Containers[i]); configPath != "" {
+			container = &pod.Spec.Containers[i]
+			break
+		}
+	}
+	if container == nil {
+		return notConfigurableError(installation, "no container of pod %s is started with %s", pod.Name, configFileFlag)
+	}
+	configMapName, configFileKey := getConfigMapKey(&pod.Spec, container, configPath)
+	if configMapName == "" {
+		return notConfigurableError(installation, "%s is not mounted from a config map", configPath)
+	}
+
+	configMap, err := api.CoreV1().ConfigMaps(installation.Namespace).Get(configMapName, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) {
+		return notConfigurableError(installation, "the service account may not access config map %s/%s, the Role and RoleBinding keptn-prometheus-service of deploy/service.yaml have to be created in namespace %s",
+			installation.Namespace, configMapName, installation.Namespace)
+	} else if err != nil {
+		return notConfigurableError(installation, "could not read config map %s/%s: %s", installation.Namespace, configMapName, err.Error())
+	}
+	content, ok := configMap.Data[configFileKey]
+	if !ok {
+		return notConfigurableError(installation, "config map %s/%s has no key %s", installation.Namespace, configMapName, configFileKey)
+	}
+	rulesFileKey, err := getRulesFileKey(&pod.Spec, container, configPath, configMapName, content)
+	if err != nil {
+		return notConfigurableError(installation, "%s", err.Error())
+	}
+
+	installation.ConfigMap = configMapName
+	installation.Selector = selector
+	installation.configFileKey = configFileKey
+	installation.rulesFileKey = rulesFileKey
+	return nil
+}
+
+// getConfigFileFlag returns the value of the --config.file flag of a container, or an empty string if it is not set
+func getConfigFileFlag(container *v1.Container) string {
+	args := append(append([]string{}, container.Command...), container.Args...)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, configFileFlag+"=") {
+			return strings.TrimPrefix(arg, configFileFlag+"=")
+		}
+		if arg == configFileFlag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// getConfigMapKey returns the config map and its key that a file of a container is mounted from. Empty strings are
+// returned if the file is not mounted from a config map.
+func getConfigMapKey(spec *v1.PodSpec, container *v1.Container, filePath string) (string, string) {
+	filePath = path.Clean(filePath)
+	for _, mount := range container.VolumeMounts {
+		var key string
+		if mount.SubPath != "" {
+			if path.Clean(mount.MountPath) != filePath {
+				continue
+			}
+			key = mount.SubPath
+		} else if path.Dir(filePath) == path.Clean(mount.MountPath) {
+			key = path.Base(filePath)
+		} else {
+			continue
+		}
+		for _, volume := range spec.Volumes {
+			if volume.Name != mount.Name || volume.ConfigMap == nil {
+				continue
+			}
+			// the items of a volume map keys to other paths
+			if len(volume.ConfigMap.Items) == 0 {
+				return volume.ConfigMap.Name, key
+			}
+			for _, item := range volume.ConfigMap.Items {
+				if item.Path == key {
+					return volume.ConfigMap.Name, item.Key
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// getRulesFileKey returns the key of the config map that holds a rule file loaded by prometheus.yml. The rule file
+// configured by PROMETHEUS_RULES_FILE is used if it matches a pattern of rule_files, otherwise a file of the config
+// map given in rule_files, preferring the alerting rules.
+func getRulesFileKey(spec *v1.PodSpec, container *v1.Container, configPath string, configMapName string, content string) (string, error) {
+	config := struct {
+		RuleFiles []string `yaml:"rule_files"`
+	}{}
+	if err := yaml.Unmarshal([]byte(content), &config); err != nil {
+		return "", fmt.Errorf("could not parse prometheus.yml: %s", err.Error())
+	}
+	promConfig, err := utils.GetPrometheusConfig()
+	if err != nil {
+		return "", err
+	}
+
+	var keys []string
+	for _, pattern := range config.RuleFiles {
+		// relative paths are resolved against the directory of prometheus.yml
+		if !path.IsAbs(pattern) {
+			pattern = path.Join(path.Dir(configPath), pattern)
+		}
+		candidate := path.Join(path.Dir(pattern), promConfig.RulesFileKey)
+		if matched, _ := path.Match(pattern, candidate); matched {
+			if name, key := getConfigMapKey(spec, container, candidate); name == configMapName {
+				return key, nil
+			}
+		}
+		if strings.ContainsAny(pattern, "*?[") {
+			continue
+		}
+		if name, key := getConfigMapKey(spec, container, pattern); name == configMapName {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if strings.Contains(key, "alert") {
+			return key, nil
+		}
+	}
+	if len(keys) > 0 {
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("rule_files of prometheus.yml do not load a file of config map %s", configMapName)
+}
@@ -0,0 +1,123 @@
+package eventhandling
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPrometheusPod(args []string, mounts []v1.VolumeMount, volumes []v1.Volume) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-server-0", Namespace: "observability", Labels: map[string]string{"app": "prometheus", "component": "server"}},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "configmap-reload", Args: []string{"--volume-dir=/etc/config"}},
+				{Name: "prometheus-server", Args: args, VolumeMounts: mounts},
+			},
+			Volumes: volumes,
+		},
+	}
+}
+
+func configMapVolume(name string, configMap string, items ...v1.KeyToPath) v1.Volume {
+	return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+		LocalObjectReference: v1.LocalObjectReference{Name: configMap},
+		Items:                items,
+	}}}
+}
+
+func TestResolveConfigMap(t *testing.T) {
+	helmConfig := "rule_files:\n- /etc/config/recording_rules.yml\n- /etc/config/alerting_rules.yml\nscrape_configs: []\n"
+
+	tests := []struct {
+		name          string
+		pod           *v1.Pod
+		config        string
+		wantConfigKey string
+		wantRulesKey  string
+		wantErr       string
+	}{
+		{
+			name:          "helm chart",
+			pod:           newPrometheusPod([]string{"--config.file=/etc/config/prometheus.yml"}, []v1.VolumeMount{{Name: "config-volume", MountPath: "/etc/config"}}, []v1.Volume{configMapVolume("config-volume", "prometheus-server")}),
+			config:        helmConfig,
+			wantConfigKey: "prometheus.yml",
+			wantRulesKey:  "alerting_rules.yml",
+		},
+		{
+			name:          "separate flag value and rules file matched by a pattern",
+			pod:           newPrometheusPod([]string{"--config.file", "/etc/config/prometheus.yml"}, []v1.VolumeMount{{Name: "config-volume", MountPath: "/etc/config/"}}, []v1.Volume{configMapVolume("config-volume", "prometheus-server")}),
+			config:        "rule_files:\n- '*.rules'\n",
+			wantConfigKey: "prometheus.yml",
+			wantRulesKey:  "prometheus.rules",
+		},
+		{
+			name: "sub path and items",
+			pod: newPrometheusPod([]string{"--config.file=/etc/prometheus/prometheus.yml"},
+				[]v1.VolumeMount{{Name: "config", MountPath: "/etc/prometheus/prometheus.yml", SubPath: "config.yml"}, {Name: "config", MountPath: "/etc/prometheus/rules"}},
+				[]v1.Volume{configMapVolume("config", "prometheus-server", v1.KeyToPath{Key: "prometheus.yml", Path: "config.yml"}, v1.KeyToPath{Key: "rules.yml", Path: "keptn.yml"})}),
+			config:        "rule_files:\n- rules/keptn.yml\n",
+			wantConfigKey: "prometheus.yml",
+			wantRulesKey:  "rules.yml",
+		},
+		{
+			name:    "no config file flag",
+			pod:     newPrometheusPod(nil, nil, nil),
+			wantErr: "is started with --config.file",
+		},
+		{
+			name:    "config file not mounted from a config map",
+			pod:     newPrometheusPod([]string{"--config.file=/etc/config/prometheus.yml"}, []v1.VolumeMount{{Name: "config-volume", MountPath: "/etc/config"}}, []v1.Volume{{Name: "config-volume"}}),
+			wantErr: "is not mounted from a config map",
+		},
+		{
+			name:    "no rule file in the config map",
+			pod:     newPrometheusPod([]string{"--config.file=/etc/config/prometheus.yml"}, []v1.VolumeMount{{Name: "config-volume", MountPath: "/etc/config"}}, []v1.Volume{configMapVolume("config-volume", "prometheus-server")}),
+			config:  "rule_files:\n- /etc/rules/*.yml\n",
+			wantErr: "do not load a file of config map prometheus-server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &v1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "prometheus-server", Namespace: "observability"},
+				Spec:       v1.ServiceSpec{Selector: map[string]string{"app": "prometheus", "component": "server"}},
+			}
+			configMap := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "prometheus-server", Namespace: "observability"},
+				Data:       map[string]string{"prometheus.yml": tt.config},
+			}
+			api := fake.NewSimpleClientset([]runtime.Object{service, configMap, tt.pod}...)
+			installation := &prometheusInstallation{Kind: installationKindService, Namespace: "observability", Name: "prometheus-server"}
+
+			err := resolvePrometheusInstallation(api, installation)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "detected but is not configurable") {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if installation.ConfigMap != "prometheus-server" || installation.Selector != "app=prometheus,component=server" {
+				t.Errorf("unexpected config map %s and selector %s", installation.ConfigMap, installation.Selector)
+			}
+			if installation.configFileKey != tt.wantConfigKey || installation.rulesFileKey != tt.wantRulesKey {
+				t.Errorf("expected keys %s and %s, got %s and %s", tt.wantConfigKey, tt.wantRulesKey, installation.configFileKey, installation.rulesFileKey)
+			}
+		})
+	}
+}
+
+func TestResolveOperatorInstallation(t *testing.T) {
+	installation := &prometheusInstallation{Kind: installationKindOperator, Namespace: "observability", Name: "k8s"}
+	err := resolvePrometheusInstallation(fake.NewSimpleClientset(), installation)
+	if err == nil || !strings.Contains(err.Error(), "Prometheus Operator instance observability/k8s has been detected but is not configurable") {
+		t.Fatalf("expected the operator instance to be reported as not configurable, got %v", err)
+	}
+}
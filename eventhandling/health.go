@@ -0,0 +1,217 @@
+package eventhandling
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+const healthPollInterval = 5 * time.Second
+
+// componentHealth is the state of a Prometheus or Alertmanager installation that is reported in the done event
+type componentHealth struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthCheck describes how the readiness of a component is checked. deployment is empty for installations that are
+// not managed by the prometheus-service, in which case only the pods and the readiness endpoint are checked.
+type healthCheck struct {
+	name       string
+	namespace  string
+	deployment string
+	selector   string
+	url        string
+}
+
+// waitForInstallationReady waits until the deployments of the installation are rolled out and the components answer
+// their readiness endpoints. An error containing the reasons is returned if a component is not ready in time.
+func waitForInstallationReady(installation *prometheusInstallation, logger keptn.LoggerInterface) ([]*componentHealth, error) {
+	promConfig, err := utils.GetPrometheusConfig()
+	if err != nil {
+		return nil, err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []healthCheck
+	switch installation.Kind {
+	case installationKindBundled:
+		checks = []healthCheck{
+			{name: "Prometheus", namespace: promConfig.Namespace, deployment: promConfig.DeploymentName, selector: promConfig.Selector, url: installation.URL},
+			{name: "Alertmanager", namespace: promConfig.AlertManagerNamespace, deployment: promConfig.AlertManagerDeploymentName, selector: promConfig.AlertManagerSelector, url: promConfig.AlertManagerURL()},
+		}
+	case installationKindConfigured:
+		checks = []healthCheck{{name: "Prometheus", namespace: promConfig.Namespace, selector: promConfig.Selector, url: installation.URL}}
+	default:
+		checks = []healthCheck{{name: "Prometheus", namespace: installation.Namespace, selector: installation.Selector, url: installation.URL}}
+	}
+
+	timeout := getReadyTimeout()
+	deadline := time.Now().Add(timeout)
+	logger.Debug(fmt.Sprintf("Waiting up to %s for %s to become ready", timeout.String(), installation.String()))
+
+	var health []*componentHealth
+	var failures []string
+	for _, check := range checks {
+		component := &componentHealth{Name: check.name, Ready: true}
+		if err := check.wait(api, deadline); err != nil {
+			component.Ready = false
+			component.Message = err.Error()
+			failures = append(failures, check.name+": "+err.Error())
+		}
+		health = append(health, component)
+	}
+	if len(failures) > 0 {
+		return health, fmt.Errorf("not ready within %s: %s", timeout.String(), strings.Join(failures, "; "))
+	}
+	return health, nil
+}
+
+func (c healthCheck) wait(api kubernetes.Interface, deadline time.Time) error {
+	if c.deployment != "" {
+		if err := waitForDeploymentRollout(api, c.namespace, c.deployment, deadline); err != nil {
+			return fmt.Errorf("%s%s", err.Error(), getPodStatusReasons(api, c.namespace, c.selector))
+		}
+	}
+	if c.url == "" {
+		return nil
+	}
+	if err := waitForReadyEndpoint(c.url, deadline); err != nil {
+		if c.selector != "" {
+			return fmt.Errorf("%s%s", err.Error(), getPodStatusReasons(api, c.namespace, c.selector))
+		}
+		return err
+	}
+	return nil
+}
+
+// waitForDeploymentRollout waits until all replicas of a deployment have been updated and are available
+func waitForDeploymentRollout(api kubernetes.Interface, namespace string, name string, deadline time.Time) error {
+	for {
+		deployment, err := api.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+		if err == nil && isDeploymentRolledOut(deployment) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("could not retrieve deployment %s/%s: %s", namespace, name, err.Error())
+			}
+			return fmt.Errorf("deployment %s/%s is not rolled out (%d of %d replicas available)",
+				namespace, name, deployment.Status.AvailableReplicas, getDesiredReplicas(deployment))
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+func isDeploymentRolledOut(deployment *appsv1.Deployment) bool {
+	replicas := getDesiredReplicas(deployment)
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas &&
+		status.AvailableReplicas == replicas
+}
+
+func getDesiredReplicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
+
+// waitForReadyEndpoint waits until the readiness endpoint of Prometheus or Alertmanager answers with status 200
+func waitForReadyEndpoint(baseURL string, deadline time.Time) error {
+	client := &http.Client{Timeout: healthPollInterval}
+	readyURL := strings.TrimSuffix(baseURL, "/") + "/-/ready"
+	for {
+		resp, err := client.Get(readyURL)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not ready: %s", readyURL, err.Error())
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+// getPodStatusReasons describes why the pods matching the selector are not ready, e.g. ImagePullBackOff or
+// CrashLoopBackOff. The result is empty or starts with a separator, so it can be appended to an error message.
+func getPodStatusReasons(api kubernetes.Interface, namespace string, selector string) string {
+	if selector == "" {
+		return ""
+	}
+	pods, err := api.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return ""
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Sprintf(", no pods with labels %s found", selector)
+	}
+	var reasons []string
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || isPodReady(pod) {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("pod %s: %s", pod.Name, describePodStatus(pod)))
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(reasons, ", ")
+}
+
+func describePodStatus(pod v1.Pod) string {
+	var reasons []string
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodScheduled && cond.Status == v1.ConditionFalse {
+			reasons = append(reasons, formatReason(cond.Reason, cond.Message))
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			continue
+		}
+		if waiting := status.State.Waiting; waiting != nil {
+			reason := fmt.Sprintf("container %s %s", status.Name, formatReason(waiting.Reason, waiting.Message))
+			// a crash-looping container is waiting, the cause is found in its last termination
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				reason += fmt.Sprintf(", last terminated with exit code %d: %s", terminated.ExitCode, formatReason(terminated.Reason, terminated.Message))
+			}
+			reasons = append(reasons, reason)
+		} else if terminated := status.State.Terminated; terminated != nil {
+			reasons = append(reasons, fmt.Sprintf("container %s terminated with exit code %d: %s", status.Name, terminated.ExitCode, formatReason(terminated.Reason, terminated.Message)))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("container %s is not ready", status.Name))
+		}
+	}
+	if len(reasons) == 0 {
+		return string(pod.Status.Phase)
+	}
+	return strings.Join(reasons, ", ")
+}
+
+func formatReason(reason string, message string) string {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return reason
+	}
+	return fmt.Sprintf("%s (%s)", reason, message)
+}
@@ -0,0 +1,94 @@
+package eventhandling
+
+import (
+	"strings"
+
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+const (
+	// targetDownAlert fires if a scrape target of the service cannot be scraped
+	targetDownAlert = "scrape_target_down"
+	// metricsAbsentAlert fires if the service is up but does not export the requests metric of its metric profile, in
+	// which case the SLO alerts have no data and never fire
+	metricsAbsentAlert = "metrics_absent"
+)
+
+// getHealthAlertingRules returns the alerting rules for the scrape jobs of a service in a stage. Each job yields a
+// series of its own, so the alerts carry the job label of the affected scrape job.
+func getHealthAlertingRules(config *utils.ServiceConfig, sliConfig *sliConfiguration, project string, stage string, service string, jobs []*scrapeJob) ([]*alertingRule, error) {
+	var down []string
+	for _, job := range jobs {
+		up, err := getSelector("up", []*promlabels.Matcher{promlabels.MustNewMatcher(promlabels.MatchEqual, "job", job.JobName)}, nil)
+		if err != nil {
+			return nil, err
+		}
+		down = append(down, up+" == 0")
+	}
+	rules := []*alertingRule{
+		{
+			Alert:  targetDownAlert,
+			Expr:   strings.Join(down, " or "),
+			For:    config.AlertDuration,
+			Labels: getAlertLabels(project, stage, service),
+			Annotations: map[string]string{
+				"summary":      targetDownAlert,
+				"descriptions": "Scrape job {{ $labels.job }} is down",
+			},
+		},
+	}
+
+	profile, err := getMetricProfile(config, sliConfig.profile)
+	if err != nil {
+		return rules, err
+	}
+	// the metric is only expected while a target of the service is up, so the alert does not fire before the service
+	// has been deployed and does not duplicate the scrape_target_down alert. The canary receives requests only during
+	// a blue/green deployment.
+	var absent []string
+	var running []string
+	description := "No " + profile.requestsMetric + " received from scrape job {{ $labels.job }}"
+	for _, job := range jobs {
+		if strings.HasSuffix(job.JobName, "-canary") {
+			continue
+		}
+		jobMatcher := []*promlabels.Matcher{promlabels.MustNewMatcher(promlabels.MatchEqual, "job", job.JobName)}
+		up, err := getSelector("up", jobMatcher, nil)
+		if err != nil {
+			return rules, err
+		}
+		running = append(running, up+" == 1")
+		if profile.scrapedByJob {
+			selector, err := getSelector(profile.requestsMetric, jobMatcher, nil)
+			if err != nil {
+				return rules, err
+			}
+			absent = append(absent, "(absent("+selector+") and on() "+up+" == 1)")
+		}
+	}
+	if !profile.scrapedByJob && len(running) > 0 {
+		// the metrics of service meshes and ingresses are not scraped by the jobs of the service
+		selector, err := getSelector(profile.requestsMetric, profile.serviceMatchers(project, stage, service), nil)
+		if err != nil {
+			return rules, err
+		}
+		absent = append(absent, "absent("+selector+") and on() ("+strings.Join(running, " or ")+")")
+		description = "No " + profile.requestsMetric + " received for service " + service + " in stage " + stage
+	}
+	if len(absent) == 0 {
+		return rules, nil
+	}
+	rules = append(rules, &alertingRule{
+		Alert:  metricsAbsentAlert,
+		Expr:   strings.Join(absent, " or "),
+		For:    config.AlertDuration,
+		Labels: getAlertLabels(project, stage, service),
+		Annotations: map[string]string{
+			"summary":      metricsAbsentAlert,
+			"descriptions": description,
+		},
+	})
+	return rules, nil
+}
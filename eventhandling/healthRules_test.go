@@ -0,0 +1,74 @@
+package eventhandling
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+func TestGetHealthAlertingRules(t *testing.T) {
+	config := &utils.ServiceConfig{
+		AlertDuration:      "10m",
+		RequestsMetric:     "http_requests_total",
+		ResponseTimeMetric: "http_response_time_milliseconds_bucket",
+	}
+	direct := []*scrapeJob{createScrapeJobConfig("sockshop", "dev", "carts", false, false)}
+	blueGreen := []*scrapeJob{
+		createScrapeJobConfig("sockshop", "dev", "carts", false, true),
+		createScrapeJobConfig("sockshop", "dev", "carts", true, false),
+	}
+
+	tests := []struct {
+		name       string
+		profile    string
+		jobs       []*scrapeJob
+		wantDown   string
+		wantAbsent string
+	}{
+		{
+			name:       "default profile",
+			profile:    utils.MetricProfileDefault,
+			jobs:       direct,
+			wantDown:   `up{job="carts-sockshop-dev"} == 0`,
+			wantAbsent: `(absent(http_requests_total{job="carts-sockshop-dev"}) and on() up{job="carts-sockshop-dev"} == 1)`,
+		},
+		{
+			name:       "canary is skipped",
+			profile:    utils.MetricProfileDefault,
+			jobs:       blueGreen,
+			wantDown:   `up{job="carts-sockshop-dev"} == 0 or up{job="carts-sockshop-dev-canary"} == 0`,
+			wantAbsent: `(absent(http_requests_total{job="carts-sockshop-dev"}) and on() up{job="carts-sockshop-dev"} == 1)`,
+		},
+		{
+			name:       "profile not scraped by the job",
+			profile:    "istio",
+			jobs:       blueGreen,
+			wantDown:   `up{job="carts-sockshop-dev"} == 0 or up{job="carts-sockshop-dev-canary"} == 0`,
+			wantAbsent: `absent(istio_requests_total{destination_workload="carts",destination_workload_namespace="sockshop-dev",reporter="destination"}) and on() (up{job="carts-sockshop-dev"} == 1)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := getHealthAlertingRules(config, &sliConfiguration{profile: tt.profile}, "sockshop", "dev", "carts", tt.jobs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 2 {
+				t.Fatalf("expected 2 rules, got %d", len(rules))
+			}
+			if rules[0].Alert != targetDownAlert || rules[0].Expr != tt.wantDown {
+				t.Errorf("expected %s, got %s: %s", tt.wantDown, rules[0].Alert, rules[0].Expr)
+			}
+			if rules[1].Alert != metricsAbsentAlert || rules[1].Expr != tt.wantAbsent {
+				t.Errorf("expected %s, got %s: %s", tt.wantAbsent, rules[1].Alert, rules[1].Expr)
+			}
+			for _, rule := range rules {
+				if _, err := parser.ParseExpr(rule.Expr); err != nil {
+					t.Errorf("invalid expression of %s: %s", rule.Alert, err.Error())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package eventhandling
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// wellKnownPrometheusSelectors are the labels that common Prometheus distributions (Helm charts, kube-prometheus)
+// put on the Prometheus service
+var wellKnownPrometheusSelectors = []string{
+	"app=prometheus,component=server",
+	"app.kubernetes.io/name=prometheus",
+	"app=prometheus-server",
+	"app=kube-prometheus-stack-prometheus",
+}
+
+var prometheusOperatorResource = schema.GroupVersionResource{
+	Group:    "monitoring.coreos.com",
+	Version:  "v1",
+	Resource: "prometheuses",
+}
+
+const (
+	installationKindConfigured = "configured"
+	installationKindService    = "service"
+	installationKindLabels     = "labels"
+	installationKindOperator   = "operator"
+	installationKindBundled    = "bundled"
+)
+
+// prometheusInstallation describes the Prometheus installation that is configured by the service
+type prometheusInstallation struct {
+	// Kind states how the installation has been found
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// URL is the address of the Prometheus HTTP API
+	URL string `json:"url,omitempty"`
+	// ConfigMap and Selector are the config map holding prometheus.yml and the pods of a detected installation, which
+	// are configured instead of the ones given by the PROMETHEUS_* settings
+	ConfigMap string `json:"configMap,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+	// configFileKey and rulesFileKey are the keys of prometheus.yml and the rule file in ConfigMap
+	configFileKey string
+	rulesFileKey  string
+}
+
+func (i *prometheusInstallation) String() string {
+	switch i.Kind {
+	case installationKindOperator:
+		return fmt.Sprintf("Prometheus Operator instance %s/%s", i.Namespace, i.Name)
+	case installationKindBundled:
+		return fmt.Sprintf("bundled Prometheus %s/%s installed by the prometheus-service", i.Namespace, i.Name)
+	default:
+		return fmt.Sprintf("Prometheus service %s/%s (detected by %s)", i.Namespace, i.Name, i.Kind)
+	}
+}
+
+// getPrometheusConfig returns the Prometheus settings with the config map, its keys and the pod selector of a detected
+// installation
+func (i *prometheusInstallation) getPrometheusConfig(promConfig *utils.PrometheusConfig) *utils.PrometheusConfig {
+	if i == nil || i.ConfigMap == "" {
+		return promConfig
+	}
+	target := *promConfig
+	target.Namespace = i.Namespace
+	target.ConfigMapName = i.ConfigMap
+	target.ConfigFileKey = i.configFileKey
+	target.RulesFileKey = i.rulesFileKey
+	target.Selector = i.Selector
+	return &target
+}
+
+// targetInstallation is the installation that has been configured last
+var targetInstallation *prometheusInstallation
+var targetInstallationMutex sync.Mutex
+
+func setTargetInstallation(installation *prometheusInstallation) {
+	targetInstallationMutex.Lock()
+	defer targetInstallationMutex.Unlock()
+	targetInstallation = installation
+}
+
+// getTargetInstallation returns the installation that is configured. It is detected if no configure-monitoring event
+// has been handled since the service started, e.g. when the drift reconciliation runs first. nil is returned if
+// there is no installation.
+func getTargetInstallation() (*prometheusInstallation, error) {
+	targetInstallationMutex.Lock()
+	defer targetInstallationMutex.Unlock()
+	if targetInstallation != nil {
+		return targetInstallation, nil
+	}
+	prometheusHelper, err := utils.NewPrometheusHelper()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize kubernetes client: %s", err.Error())
+	}
+	status, err := prometheusHelper.GetInstallationStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status != nil {
+		targetInstallation = getBundledInstallation(prometheusHelper.Config)
+		return targetInstallation, nil
+	}
+	installation, err := findPrometheusInstallation(keptn.NewLogger("", "", "prometheus-service"))
+	if err != nil || installation == nil {
+		return nil, err
+	}
+	if err := resolvePrometheusInstallation(prometheusHelper.KubeApi, installation); err != nil {
+		return nil, err
+	}
+	targetInstallation = installation
+	return targetInstallation, nil
+}
+
+// getTargetPrometheusConfig returns the Prometheus settings of the installation that is configured
+func getTargetPrometheusConfig() (*utils.PrometheusConfig, error) {
+	promConfig, err := utils.GetPrometheusConfig()
+	if err != nil {
+		return nil, err
+	}
+	installation, err := getTargetInstallation()
+	if err != nil {
+		return nil, err
+	}
+	return installation.getPrometheusConfig(promConfig), nil
+}
+
+// findPrometheusInstallation searches the cluster for an existing Prometheus: the configured service first, then
+// well-known service names and labels in all namespaces and finally Prometheus Operator instances.
+// nil is returned if no installation has been found.
+func findPrometheusInstallation(logger keptn.LoggerInterface) (*prometheusInstallation, error) {
+	promConfig, err := utils.GetPrometheusConfig()
+	if err != nil {
+		return nil, err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize kubernetes client: %s", err.Error())
+	}
+
+	logger.Debug(fmt.Sprintf("Check if prometheus service %s in namespace %s is available", promConfig.ServiceName, promConfig.Namespace))
+	if service, err := api.CoreV1().Services(promConfig.Namespace).Get(promConfig.ServiceName, metav1.GetOptions{}); err == nil {
+		return &prometheusInstallation{Kind: installationKindConfigured, Namespace: service.Namespace, Name: service.Name, URL: getServiceURL(service)}, nil
+	}
+
+	if installation := findPrometheusServiceByName(api, logger); installation != nil {
+		return installation, nil
+	}
+	if installation := findPrometheusServiceByLabels(api, logger); installation != nil {
+		return installation, nil
+	}
+	if installation := findPrometheusOperatorInstance(logger); installation != nil {
+		return installation, nil
+	}
+	logger.Debug("No Prometheus installation found")
+	return nil, nil
+}
+
+func findPrometheusServiceByName(api kubernetes.Interface, logger keptn.LoggerInterface) *prometheusInstallation {
+	config, err := utils.GetConfig()
+	if err != nil {
+		logger.Debug("Could not read the service configuration: " + err.Error())
+		return nil
+	}
+	services, err := api.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		logger.Debug("Could not list services: " + err.Error())
+		return nil
+	}
+	for _, name := range config.DetectServices {
+		for _, service := range services.Items {
+			if service.Name == strings.TrimSpace(name) {
+				return &prometheusInstallation{Kind: installationKindService, Namespace: service.Namespace, Name: service.Name, URL: getServiceURL(&service)}
+			}
+		}
+	}
+	return nil
+}
+
+func findPrometheusServiceByLabels(api kubernetes.Interface, logger keptn.LoggerInterface) *prometheusInstallation {
+	for _, selector := range wellKnownPrometheusSelectors {
+		services, err := api.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			logger.Debug("Could not list services: " + err.Error())
+			return nil
+		}
+		if len(services.Items) > 0 {
+			service := services.Items[0]
+			return &prometheusInstallation{Kind: installationKindLabels, Namespace: service.Namespace, Name: service.Name, URL: getServiceURL(&service)}
+		}
+	}
+	return nil
+}
+
+func findPrometheusOperatorInstance(logger keptn.LoggerInterface) *prometheusInstallation {
+	client, err := utils.GetDynamicClient()
+	if err != nil {
+		return nil
+	}
+	// the request fails if the Prometheus Operator CRDs are not installed
+	instances, err := client.Resource(prometheusOperatorResource).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		logger.Debug("No Prometheus Operator instances available: " + err.Error())
+		return nil
+	}
+	if len(instances.Items) == 0 {
+		return nil
+	}
+	instance := instances.Items[0]
+	// the operator exposes all instances of a namespace with the governing service prometheus-operated
+	return &prometheusInstallation{
+		Kind:      installationKindOperator,
+		Namespace: instance.GetNamespace(),
+		Name:      instance.GetName(),
+		URL:       fmt.Sprintf("http://prometheus-operated.%s.svc:9090", instance.GetNamespace()),
+	}
+}
+
+// getServiceURL returns the address of the first port of a service
+func getServiceURL(service *v1.Service) string {
+	port := int32(80)
+	if len(service.Spec.Ports) > 0 {
+		port = service.Spec.Ports[0].Port
+	}
+	return fmt.Sprintf("http://%s.%s.svc:%d", service.Name, service.Namespace, port)
+}
+
+// ensurePrometheusInstallation returns the Prometheus installation to configure. The bundled Prometheus and
+// Alertmanager are upgraded if they have been installed with older templates or other images, and installed if no
+// installation is found, unless the installation is disabled.
+func ensurePrometheusInstallation(logger keptn.LoggerInterface) (*prometheusInstallation, error) {
+	prometheusHelper, err := utils.NewPrometheusHelper()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize kubernetes client: %s", err.Error())
+	}
+	status, err := prometheusHelper.GetInstallationStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status != nil {
+		installation := getBundledInstallation(prometheusHelper.Config)
+		setTargetInstallation(installation)
+		if !status.NeedsUpgrade(prometheusHelper) || isInstallDisabled() {
+			return installation, nil
+		}
+		logger.Info(fmt.Sprintf("Upgrading bundled Prometheus from stack version %d to %d to apply changed templates, images or settings", status.StackVersion, utils.StackVersion))
+		if err := installPrometheusStack(prometheusHelper, status.StackVersion, logger); err != nil {
+			return nil, fmt.Errorf("could not upgrade bundled Prometheus: %s", err.Error())
+		}
+		if _, err := waitForInstallationReady(installation, logger); err != nil {
+			return nil, fmt.Errorf("upgraded bundled Prometheus did not become ready: %s", err.Error())
+		}
+		return installation, nil
+	}
+
+	installation, err := findPrometheusInstallation(logger)
+	if err != nil {
+		return nil, err
+	}
+	if installation != nil {
+		if prometheusHelper.Config.Endpoint != "" {
+			installation.URL = prometheusHelper.Config.PrometheusURL()
+		}
+		logger.Info("Found " + installation.String())
+		if err := resolvePrometheusInstallation(prometheusHelper.KubeApi, installation); err != nil {
+			return nil, err
+		}
+		if installation.ConfigMap != "" {
+			logger.Info(fmt.Sprintf("Prometheus configuration is written to config map %s/%s", installation.Namespace, installation.ConfigMap))
+		}
+		setTargetInstallation(installation)
+		return installation, nil
+	}
+
+	if isInstallDisabled() {
+		return nil, errors.New("no Prometheus installation found and the installation of Prometheus is disabled")
+	}
+
+	if err := installPrometheusStack(prometheusHelper, utils.StackVersion, logger); err != nil {
+		return nil, err
+	}
+	installation = getBundledInstallation(prometheusHelper.Config)
+	setTargetInstallation(installation)
+	if _, err := waitForInstallationReady(installation, logger); err != nil {
+		return nil, fmt.Errorf("installed bundled Prometheus did not become ready: %s", err.Error())
+	}
+	return installation, nil
+}
+
+func getBundledInstallation(promConfig *utils.PrometheusConfig) *prometheusInstallation {
+	return &prometheusInstallation{
+		Kind:      installationKindBundled,
+		Namespace: promConfig.Namespace,
+		Name:      promConfig.ServiceName,
+		URL:       promConfig.PrometheusURL(),
+	}
+}
+
+// installPrometheusStack installs or upgrades the bundled Prometheus and Alertmanager and records the installed version
+func installPrometheusStack(prometheusHelper *utils.PrometheusHelper, fromVersion int, logger keptn.LoggerInterface) error {
+	// objects of installations that did not track their version carry no labels yet
+	prometheusHelper.AdoptExisting = fromVersion < utils.StackVersion
+
+	logger.Debug("Installing prometheus monitoring")
+	if err := installPrometheus(prometheusHelper, fromVersion, logger); err != nil {
+		return err
+	}
+
+	logger.Debug("Installing prometheus alert manager")
+	if err := installPrometheusAlertManager(prometheusHelper, logger); err != nil {
+		return err
+	}
+
+	return prometheusHelper.StoreInstallationStatus()
+}
+
+// uninstallResponse lists the objects removed by an uninstall
+type uninstallResponse struct {
+	Removed []string `json:"removed"`
+	Message string   `json:"message"`
+}
+
+// HandleUninstall removes the bundled Prometheus and Alertmanager. The Prometheus data is only removed if the query
+// parameter purge is set to true.
+func HandleUninstall(rw http.ResponseWriter, req *http.Request) {
+	logger := keptn.NewLogger(uuid.New().String(), "", "prometheus-service")
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdminRequest(rw, req) {
+		return
+	}
+	prometheusHelper, err := utils.NewPrometheusHelper()
+	if err != nil {
+		logger.Error("Could not initialize kubernetes client: " + err.Error())
+		writeJSON(rw, http.StatusInternalServerError, uninstallResponse{Message: err.Error()})
+		return
+	}
+
+	prometheusConfigMutex.Lock()
+	defer prometheusConfigMutex.Unlock()
+	setTargetInstallation(nil)
+
+	removed, err := prometheusHelper.Uninstall(req.URL.Query().Get("purge") == "true")
+	for _, object := range removed {
+		logger.Info("Removed " + object)
+	}
+	if err != nil {
+		logger.Error("Could not uninstall bundled Prometheus: " + err.Error())
+		writeJSON(rw, http.StatusInternalServerError, uninstallResponse{Removed: removed, Message: err.Error()})
+		return
+	}
+	if len(removed) == 0 {
+		writeJSON(rw, http.StatusOK, uninstallResponse{Removed: removed, Message: "No bundled Prometheus installed"})
+		return
+	}
+	writeJSON(rw, http.StatusOK, uninstallResponse{Removed: removed, Message: "Bundled Prometheus uninstalled"})
+}
+
+// isInstallDisabled returns true if the bundled Prometheus must not be installed or upgraded
+func isInstallDisabled() bool {
+	config, err := utils.GetConfig()
+	return err == nil && config.InstallMode == utils.InstallModeNever
+}
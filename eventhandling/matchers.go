@@ -0,0 +1,103 @@
+package eventhandling
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// filterOperators are the matching operators that can be prepended to the value of a filter, e.g. "!=HealthCheck".
+// The two-character operators are checked first, so "=~" is not taken for "=".
+var filterOperators = []struct {
+	prefix    string
+	matchType promlabels.MatchType
+}{
+	{"=~", promlabels.MatchRegexp},
+	{"!~", promlabels.MatchNotRegexp},
+	{"!=", promlabels.MatchNotEqual},
+	{"=", promlabels.MatchEqual},
+}
+
+// getFilterMatchers returns the label matchers of the filters of the SLO file, sorted by label name. Invalid label
+// names and regular expressions are reported as error.
+func getFilterMatchers(filters map[string]string) ([]*promlabels.Matcher, error) {
+	keys := make([]string, 0, len(filters))
+	for key := range filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var matchers []*promlabels.Matcher
+	for _, key := range keys {
+		matcher, err := parseFilterMatcher(key, filters[key])
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// parseFilterMatcher turns a filter into a label matcher. Without an operator, the value is matched exactly, e.g.
+// handler: ItemsController. A valid operator (=, !=, =~, !~) prepended to the value is used instead, e.g.
+// handler: =~.+ItemsController|.+VersionController. Quotes around the value are optional.
+func parseFilterMatcher(key string, value string) (*promlabels.Matcher, error) {
+	if !model.LabelName(key).IsValid() {
+		return nil, fmt.Errorf("invalid filter %s: not a valid label name", key)
+	}
+	matchType := promlabels.MatchEqual
+	for _, operator := range filterOperators {
+		if strings.HasPrefix(value, operator.prefix) {
+			matchType = operator.matchType
+			value = strings.TrimPrefix(value, operator.prefix)
+			break
+		}
+	}
+	value = unquoteFilterValue(value)
+	if matchType == promlabels.MatchRegexp || matchType == promlabels.MatchNotRegexp {
+		// checked before the matcher anchors the expression, so the error refers to the filter as written
+		if _, err := regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid filter %s: %s", key, err.Error())
+		}
+	}
+	matcher, err := promlabels.NewMatcher(matchType, key, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %s: %s", key, err.Error())
+	}
+	return matcher, nil
+}
+
+// unquoteFilterValue removes the single or double quotes around a filter value. Quotes within the value are kept and
+// escaped when the selector is rendered.
+func unquoteFilterValue(value string) string {
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// getSelector renders the selector of a metric with the matchers identifying the service, the matchers of the
+// filters and the given additional matchers, e.g. http_requests_total{handler="ItemsController",job="carts-sockshop-dev"}
+func getSelector(metric string, serviceMatchers []*promlabels.Matcher, filters map[string]string, additional ...*promlabels.Matcher) (string, error) {
+	if !model.IsValidMetricName(model.LabelValue(metric)) {
+		return "", fmt.Errorf("invalid metric name %q", metric)
+	}
+	filterMatchers, err := getFilterMatchers(filters)
+	if err != nil {
+		return "", err
+	}
+	var matchers []*promlabels.Matcher
+	matchers = append(matchers, serviceMatchers...)
+	matchers = append(matchers, filterMatchers...)
+	matchers = append(matchers, additional...)
+	selector := &parser.VectorSelector{
+		Name:          metric,
+		LabelMatchers: matchers,
+	}
+	// the matchers are printed in sorted order, so the rules do not change between runs
+	return selector.String(), nil
+}
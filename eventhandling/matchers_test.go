@@ -0,0 +1,110 @@
+package eventhandling
+
+import (
+	"testing"
+
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestParseFilterMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "exact value", key: "handler", value: "ItemsController", want: `handler="ItemsController"`},
+		{name: "single quotes", key: "handler", value: "'ItemsController'", want: `handler="ItemsController"`},
+		{name: "double quotes", key: "handler", value: `"ItemsController"`, want: `handler="ItemsController"`},
+		{name: "unbalanced quotes are kept", key: "handler", value: `'ItemsController"`, want: `handler="'ItemsController\""`},
+		{name: "quotes within the value are escaped", key: "path", value: `a"b\c`, want: `path="a\"b\\c"`},
+		{name: "not equal", key: "handler", value: "!=HealthCheck", want: `handler!="HealthCheck"`},
+		{name: "regexp", key: "handler", value: "=~.+ItemsController|.+VersionController", want: `handler=~".+ItemsController|.+VersionController"`},
+		{name: "quoted regexp", key: "handler", value: "=~'Items.*'", want: `handler=~"Items.*"`},
+		{name: "not regexp", key: "status", value: "!~2..", want: `status!~"2.."`},
+		{name: "explicit equal", key: "handler", value: "==x", want: `handler="=x"`},
+		{name: "empty value", key: "handler", value: "", want: `handler=""`},
+		{name: "invalid label name", key: "my-handler", value: "x", wantErr: true},
+		{name: "invalid regexp", key: "handler", value: "=~(", wantErr: true},
+		{name: "invalid not regexp", key: "handler", value: "!~[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := parseFilterMatcher(tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && matcher.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, matcher.String())
+			}
+		})
+	}
+}
+
+func TestGetSelector(t *testing.T) {
+	job, err := promlabels.NewMatcher(promlabels.MatchEqual, "job", "carts-sockshop-dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	le, err := promlabels.NewMatcher(promlabels.MatchEqual, "le", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		metric     string
+		filters    map[string]string
+		additional []*promlabels.Matcher
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:   "service matcher only",
+			metric: "http_requests_total",
+			want:   `http_requests_total{job="carts-sockshop-dev"}`,
+		},
+		{
+			name:    "filters are sorted by label name",
+			metric:  "http_requests_total",
+			filters: map[string]string{"status": "!~2..", "handler": "ItemsController", "method": "=~GET|POST"},
+			want:    `http_requests_total{handler="ItemsController",job="carts-sockshop-dev",method=~"GET|POST",status!~"2.."}`,
+		},
+		{
+			name:       "additional matchers",
+			metric:     "http_response_time_milliseconds_bucket",
+			filters:    map[string]string{"handler": "'x'"},
+			additional: []*promlabels.Matcher{le},
+			want:       `http_response_time_milliseconds_bucket{handler="x",job="carts-sockshop-dev",le="0.5"}`,
+		},
+		{
+			name:    "escaped filter value",
+			metric:  "http_requests_total",
+			filters: map[string]string{"path": `/a"b`},
+			want:    `http_requests_total{job="carts-sockshop-dev",path="/a\"b"}`,
+		},
+		{
+			name:    "invalid metric name",
+			metric:  "http-requests",
+			wantErr: true,
+		},
+		{
+			name:    "invalid filter",
+			metric:  "http_requests_total",
+			filters: map[string]string{"handler": "=~("},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getSelector(tt.metric, []*promlabels.Matcher{job}, tt.filters, tt.additional...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
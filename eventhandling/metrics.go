@@ -0,0 +1,33 @@
+package eventhandling
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	reconcileRunsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prometheus_service_reconcile_runs_total",
+		Help: "Number of reconciliations of the managed Prometheus configuration by result.",
+	}, []string{"result"})
+
+	reconcileDriftTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prometheus_service_reconcile_drift_total",
+		Help: "Number of scrape jobs and rule groups found to differ from the desired configuration.",
+	}, []string{"kind"})
+
+	reconcileRepairsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prometheus_service_reconcile_repairs_total",
+		Help: "Number of times the Prometheus configuration has been repaired.",
+	})
+
+	reconcileDriftDetected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prometheus_service_reconcile_drift_detected",
+		Help: "Whether the last reconciliation found drift in the Prometheus configuration.",
+	})
+
+	reconcileLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "prometheus_service_reconcile_last_success_timestamp_seconds",
+		Help: "Time of the last successful reconciliation.",
+	})
+)
@@ -0,0 +1,365 @@
+package eventhandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go"
+	"github.com/cloudevents/sdk-go/pkg/cloudevents/types"
+	"github.com/google/uuid"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+const (
+	changeAdded     = "added"
+	changeChanged   = "changed"
+	changeUnchanged = "unchanged"
+)
+
+// configureOptions are the fields of a configure-monitoring event that are specific to the prometheus-service
+type configureOptions struct {
+	// DryRun returns the plan of the changes instead of applying them
+	DryRun bool `json:"dryRun"`
+	// Services are configured in addition to the service of the event. If neither is set, all services of the
+	// project are configured.
+	Services []string `json:"services,omitempty"`
+}
+
+// configurePlan describes the changes a configure-monitoring event would apply
+type configurePlan struct {
+	Installation *prometheusInstallation `json:"installation,omitempty"`
+	// Services are the services the plan is computed for
+	Services []string `json:"services"`
+	// Install is set if no Prometheus installation is found and the bundled Prometheus would be installed
+	Install bool `json:"install"`
+	// Upgrade is set if the bundled Prometheus would be upgraded
+	Upgrade bool `json:"upgrade"`
+	// Restart is set if the configuration changes and Prometheus would be restarted
+	Restart    bool               `json:"restart"`
+	ScrapeJobs []*scrapeJobChange `json:"scrapeJobs"`
+	RuleGroups []*ruleGroupChange `json:"ruleGroups"`
+	// ScrapeConfigs and Rules are the planned scrape jobs and rule groups generated for the services. The rest of
+	// prometheus.yml is left out, as it may contain credentials.
+	ScrapeConfigs string `json:"scrapeConfigs"`
+	Rules         string `json:"rules"`
+}
+
+type scrapeJobChange struct {
+	Job    string `json:"job"`
+	Action string `json:"action"`
+}
+
+type ruleGroupChange struct {
+	Group        string   `json:"group"`
+	Action       string   `json:"action"`
+	AddedRules   []string `json:"addedRules,omitempty"`
+	ChangedRules []string `json:"changedRules,omitempty"`
+	RemovedRules []string `json:"removedRules,omitempty"`
+}
+
+// planPrometheusConfig computes the changes of a configure-monitoring event against the current Prometheus config
+// map without modifying the cluster
+func planPrometheusConfig(eventData *keptn.ConfigureMonitoringEventData, options *configureOptions, logger keptn.LoggerInterface, keptnHandler *keptn.Keptn) (*configurePlan, error) {
+	prometheusHelper, err := utils.NewPrometheusHelper()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize kubernetes client: %s", err.Error())
+	}
+	promConfig := prometheusHelper.Config
+	plan := &configurePlan{}
+
+	status, err := prometheusHelper.GetInstallationStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status != nil {
+		plan.Installation = getBundledInstallation(promConfig)
+		plan.Upgrade = status.NeedsUpgrade(prometheusHelper) && !isInstallDisabled()
+	} else {
+		plan.Installation, err = findPrometheusInstallation(logger)
+		if err != nil {
+			return nil, err
+		}
+		if plan.Installation == nil {
+			if isInstallDisabled() {
+				return nil, fmt.Errorf("no Prometheus installation found and the installation of Prometheus is disabled")
+			}
+			plan.Install = true
+			plan.Installation = getBundledInstallation(promConfig)
+		} else {
+			if err := resolvePrometheusInstallation(prometheusHelper.KubeApi, plan.Installation); err != nil {
+				return nil, err
+			}
+			promConfig = plan.Installation.getPrometheusConfig(promConfig)
+		}
+	}
+
+	current, err := prometheusHelper.KubeApi.CoreV1().ConfigMaps(promConfig.Namespace).Get(promConfig.ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) && plan.Install {
+		initialConfig, err := prometheusHelper.RenderPrometheusConfig()
+		if err != nil {
+			return nil, err
+		}
+		current = &v1.ConfigMap{Data: map[string]string{promConfig.ConfigFileKey: initialConfig}}
+	} else if err != nil {
+		return nil, err
+	}
+
+	desiredConfig, err := getMonitoringConfig(*eventData, options, logger, keptnHandler)
+	if err != nil {
+		return nil, err
+	}
+	plan.Services = desiredConfig.services()
+	planned := current.DeepCopy()
+	if err := applyMonitoringConfig(planned, desiredConfig, promConfig); err != nil {
+		return nil, err
+	}
+
+	plannedConfig := planned.Data[promConfig.ConfigFileKey]
+	plannedRules := planned.Data[promConfig.RulesFileKey]
+	plan.Restart = plan.Install || plan.Upgrade ||
+		plannedConfig != current.Data[promConfig.ConfigFileKey] ||
+		plannedRules != current.Data[promConfig.RulesFileKey]
+
+	plan.ScrapeJobs, err = diffScrapeJobs(current.Data[promConfig.ConfigFileKey], plannedConfig, desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+	plan.RuleGroups, err = diffRuleGroups(current.Data[promConfig.RulesFileKey], plannedRules, desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+	plan.ScrapeConfigs, plan.Rules, err = renderManagedConfig(plannedRules, desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// renderManagedConfig renders the generated scrape jobs and the planned rule groups of the services as YAML
+func renderManagedConfig(plannedRules string, desiredConfig *monitoringConfig) (string, string, error) {
+	jobs := struct {
+		ScrapeConfigs []*scrapeJob `yaml:"scrape_configs"`
+	}{}
+	for _, stage := range desiredConfig.stages {
+		jobs.ScrapeConfigs = append(jobs.ScrapeConfigs, stage.scrapeJobs...)
+	}
+	renderedJobs, err := yaml.Marshal(jobs)
+	if err != nil {
+		return "", "", err
+	}
+
+	planned, err := parseAlertingRules(plannedRules)
+	if err != nil {
+		return "", "", err
+	}
+	groups := &alertingRules{}
+	for _, stage := range desiredConfig.stages {
+		if stage.alertingGroup == nil {
+			continue
+		}
+		if group := getAlertingGroup(planned, stage.alertingGroup.name); group != nil {
+			groups.Groups = append(groups.Groups, group)
+		}
+	}
+	renderedGroups, err := yaml.Marshal(groups)
+	if err != nil {
+		return "", "", err
+	}
+	return string(renderedJobs), string(renderedGroups), nil
+}
+
+// diffScrapeJobs compares the generated scrape jobs in the current and the planned prometheus.yml
+func diffScrapeJobs(currentConfig string, plannedConfig string, desiredConfig *monitoringConfig) ([]*scrapeJobChange, error) {
+	currentJobs, err := getRenderedScrapeJobs(currentConfig)
+	if err != nil {
+		return nil, err
+	}
+	plannedJobs, err := getRenderedScrapeJobs(plannedConfig)
+	if err != nil {
+		return nil, err
+	}
+	changes := []*scrapeJobChange{}
+	for _, stage := range desiredConfig.stages {
+		for _, job := range stage.scrapeJobs {
+			change := &scrapeJobChange{Job: job.JobName, Action: changeUnchanged}
+			if current, ok := currentJobs[job.JobName]; !ok {
+				change.Action = changeAdded
+			} else if current != plannedJobs[job.JobName] {
+				change.Action = changeChanged
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// getRenderedScrapeJobs returns the scrape jobs of a prometheus.yml rendered as YAML by their job name
+func getRenderedScrapeJobs(config string) (map[string]string, error) {
+	parsed := struct {
+		ScrapeConfigs []map[string]interface{} `yaml:"scrape_configs"`
+	}{}
+	if err := yaml.Unmarshal([]byte(config), &parsed); err != nil {
+		return nil, fmt.Errorf("could not parse prometheus.yml: %s", err.Error())
+	}
+	jobs := map[string]string{}
+	for _, job := range parsed.ScrapeConfigs {
+		name, _ := job["job_name"].(string)
+		if _, ok := jobs[name]; ok || name == "" {
+			continue
+		}
+		out, err := yaml.Marshal(job)
+		if err != nil {
+			return nil, err
+		}
+		jobs[name] = string(out)
+	}
+	return jobs, nil
+}
+
+// diffRuleGroups compares the generated rule groups in the current and the planned rule file
+func diffRuleGroups(currentRules string, plannedRules string, desiredConfig *monitoringConfig) ([]*ruleGroupChange, error) {
+	current, err := parseAlertingRules(currentRules)
+	if err != nil {
+		return nil, err
+	}
+	planned, err := parseAlertingRules(plannedRules)
+	if err != nil {
+		return nil, err
+	}
+	changes := []*ruleGroupChange{}
+	for _, stage := range desiredConfig.stages {
+		if stage.alertingGroup == nil {
+			continue
+		}
+		change := &ruleGroupChange{Group: stage.alertingGroup.name, Action: changeUnchanged}
+		currentGroup := getAlertingGroup(current, change.Group)
+		plannedGroup := getAlertingGroup(planned, change.Group)
+		if plannedGroup == nil {
+			// neither the current nor the planned rule file contain rules for this stage
+			continue
+		}
+		if currentGroup == nil {
+			change.Action = changeAdded
+			currentGroup = &alertingGroup{}
+		}
+		for _, rule := range plannedGroup.Rules {
+			currentRule := getAlertingRule(currentGroup.Rules, rule.key())
+			if currentRule == nil {
+				change.AddedRules = append(change.AddedRules, rule.key())
+			} else if !isSameRule(currentRule, rule) {
+				change.ChangedRules = append(change.ChangedRules, rule.key())
+			}
+		}
+		for _, rule := range currentGroup.Rules {
+			if getAlertingRule(plannedGroup.Rules, rule.key()) == nil {
+				change.RemovedRules = append(change.RemovedRules, rule.key())
+			}
+		}
+		if change.Action == changeUnchanged && len(change.AddedRules)+len(change.ChangedRules)+len(change.RemovedRules) > 0 {
+			change.Action = changeChanged
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func isSameRule(a *alertingRule, b *alertingRule) bool {
+	outA, errA := yaml.Marshal(a)
+	outB, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && string(outA) == string(outB)
+}
+
+// summary describes the plan in one sentence for the done event
+func (p *configurePlan) summary() string {
+	var parts []string
+	if p.Install {
+		parts = append(parts, "Prometheus would be installed")
+	}
+	if p.Upgrade {
+		parts = append(parts, "Prometheus would be upgraded")
+	}
+	jobs := 0
+	for _, job := range p.ScrapeJobs {
+		if job.Action != changeUnchanged {
+			jobs++
+		}
+	}
+	groups := 0
+	for _, group := range p.RuleGroups {
+		if group.Action != changeUnchanged {
+			groups++
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%d scrape job(s) and %d rule group(s) would change", jobs, groups))
+	if p.Restart {
+		parts = append(parts, "Prometheus would be restarted")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// HandleConfigPlan returns the plan of a configure-monitoring event given as request body without applying it
+func HandleConfigPlan(rw http.ResponseWriter, req *http.Request) {
+	keptnContext := uuid.New().String()
+	logger := keptn.NewLogger(keptnContext, "", "prometheus-service")
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdminRequest(rw, req) {
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	eventData := &keptn.ConfigureMonitoringEventData{}
+	if err := json.Unmarshal(body, eventData); err != nil || eventData.Project == "" {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"message": "request body must contain the project"})
+		return
+	}
+	options := &configureOptions{}
+	_ = json.Unmarshal(body, options)
+
+	keptnHandler, err := newKeptnHandler(eventData, keptnContext)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+
+	plan, err := planPrometheusConfig(eventData, options, logger, keptnHandler)
+	if err != nil {
+		logger.Error("Could not plan the Prometheus configuration: " + err.Error())
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, plan)
+}
+
+// newKeptnHandler creates a keptn handler for a configure-monitoring request that has not been received as event
+func newKeptnHandler(eventData *keptn.ConfigureMonitoringEventData, keptnContext string) (*keptn.Keptn, error) {
+	// the keptn handler reads the project of the configure-monitoring event
+	source, _ := url.Parse("prometheus-service")
+	event := cloudevents.Event{
+		Context: cloudevents.EventContextV02{
+			ID:         uuid.New().String(),
+			Time:       &types.Timestamp{Time: time.Now()},
+			Type:       keptn.ConfigureMonitoringEventType,
+			Source:     types.URLRef{URL: *source},
+			Extensions: map[string]interface{}{"shkeptncontext": keptnContext},
+		}.AsV02(),
+		Data: eventData,
+	}
+	return keptn.NewKeptn(&event, keptn.KeptnOpts{ConfigurationServiceURL: getConfigurationServiceURL()})
+}
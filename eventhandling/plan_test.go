@@ -0,0 +1,65 @@
+package eventhandling
+
+import "testing"
+
+func TestRenderManagedConfig(t *testing.T) {
+	groupName := getAlertingGroupName("sockshop", "dev", "carts")
+	desiredConfig := &monitoringConfig{stages: []*stageMonitoringConfig{
+		{
+			project:       "sockshop",
+			stage:         "dev",
+			service:       "carts",
+			scrapeJobs:    []*scrapeJob{createScrapeJobConfig("sockshop", "dev", "carts", false, false)},
+			alertingGroup: &managedAlertingGroup{name: groupName},
+		},
+		{
+			project:    "sockshop",
+			stage:      "staging",
+			service:    "carts",
+			scrapeJobs: []*scrapeJob{createScrapeJobConfig("sockshop", "staging", "carts", false, false)},
+		},
+	}}
+	plannedRules := `groups:
+- name: user group
+  rules:
+  - alert: user_alert
+    expr: up == 0
+- name: ` + groupName + `
+  rules:
+  - alert: response_time_p90
+    expr: x > 200
+`
+
+	scrapeConfigs, rules, err := renderManagedConfig(plannedRules, desiredConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantScrapeConfigs := `scrape_configs:
+- job_name: carts-sockshop-dev
+  metrics_path: /prometheus
+  static_configs:
+  - targets:
+    - carts.sockshop-dev:80
+- job_name: carts-sockshop-staging
+  metrics_path: /prometheus
+  static_configs:
+  - targets:
+    - carts.sockshop-staging:80
+`
+	if scrapeConfigs != wantScrapeConfigs {
+		t.Errorf("expected scrape configs\n%s\ngot\n%s", wantScrapeConfigs, scrapeConfigs)
+	}
+	wantRules := `groups:
+- name: carts sockshop-dev alerts
+  rules:
+  - alert: response_time_p90
+    expr: x > 200
+`
+	if rules != wantRules {
+		t.Errorf("expected rules\n%s\ngot\n%s", wantRules, rules)
+	}
+
+	if _, _, err := renderManagedConfig("groups: [", desiredConfig); err == nil {
+		t.Error("expected an error for an invalid rule file")
+	}
+}
@@ -0,0 +1,98 @@
+package eventhandling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultPredictLookback = "1h"
+	defaultPredictHorizon  = "4h"
+)
+
+// predictOptions make the threshold alerts of an objective fire when the SLI is predicted to cross the threshold
+// within the horizon, based on its linear trend over the lookback
+type predictOptions struct {
+	Lookback string `yaml:"lookback"`
+	Horizon  string `yaml:"horizon"`
+}
+
+// predictionObjectives holds the predict option of the objectives of an SLO file, which is not part of the SLO
+// format and therefore ignored by other Keptn services
+type predictionObjectives struct {
+	Objectives []*struct {
+		SLI     string          `yaml:"sli"`
+		Predict *predictOptions `yaml:"predict"`
+	} `yaml:"objectives"`
+}
+
+// getPredictOptions returns the predict options of the objectives of an SLO file by SLI. An error is returned for an
+// invalid lookback or horizon, so the objective does not silently fall back to its threshold alert.
+func getPredictOptions(content string) (map[string]*predictOptions, error) {
+	objectives := predictionObjectives{}
+	if err := yaml.Unmarshal([]byte(content), &objectives); err != nil {
+		return nil, err
+	}
+	options := map[string]*predictOptions{}
+	for _, objective := range objectives.Objectives {
+		if objective != nil && objective.Predict != nil {
+			if _, _, _, err := objective.Predict.window(); err != nil {
+				return nil, fmt.Errorf("invalid predict option of SLI %s: %s", objective.SLI, err.Error())
+			}
+			options[objective.SLI] = objective.Predict
+		}
+	}
+	return options, nil
+}
+
+// window returns the lookback and the horizon of the prediction with their defaults applied
+func (o *predictOptions) window() (string, string, model.Duration, error) {
+	lookback := o.Lookback
+	if lookback == "" {
+		lookback = defaultPredictLookback
+	}
+	horizon := o.Horizon
+	if horizon == "" {
+		horizon = defaultPredictHorizon
+	}
+	if _, err := model.ParseDuration(lookback); err != nil {
+		return "", "", 0, fmt.Errorf("invalid lookback %s: %s", lookback, err.Error())
+	}
+	horizonDuration, err := model.ParseDuration(horizon)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("invalid horizon %s: %s", horizon, err.Error())
+	}
+	return lookback, horizon, horizonDuration, nil
+}
+
+// getPredictiveExpr returns the expression of a threshold alert that fires when the SLI is predicted to cross the
+// threshold, e.g. predict_linear((<sli query>)[1h:], 14400) > 600, together with the annotations explaining it
+func getPredictiveExpr(sli string, expr string, criteriaString string, options *predictOptions) (string, map[string]string, error) {
+	lookback, horizon, horizonDuration, err := options.window()
+	if err != nil {
+		return "", nil, err
+	}
+	horizonSeconds := strconv.FormatFloat(time.Duration(horizonDuration).Seconds(), 'f', -1, 64)
+	threshold := strings.TrimSpace(strings.TrimLeft(criteriaString, "<>"))
+
+	// the SLI queries are instant vectors, so their range is taken by a subquery
+	predictive := "predict_linear((" + expr + ")[" + lookback + ":], " + horizonSeconds + ")" + criteriaString
+	description := sli + " is predicted to cross " + threshold + " within " + horizon + " based on its trend over the last " + lookback
+	// the time of the crossing follows from the distance to the threshold and the current slope of the SLI. The
+	// query is a raw string of the alert template, so it must not contain backquotes.
+	if !strings.Contains(expr, "`") {
+		eta := "(" + threshold + " - (" + expr + ")) / deriv((" + expr + ")[" + lookback + ":])"
+		description = description + ", {{ with query `" + eta + "` }}{{ $eta := . | first | value }}{{ if gt $eta 0.0 }}in about {{ humanizeDuration $eta }}{{ else }}now{{ end }}{{ end }}"
+	}
+	annotations := map[string]string{
+		"summary":         sli,
+		"descriptions":    description,
+		"predicted_value": "{{ $value }}",
+	}
+	return predictive, annotations, nil
+}
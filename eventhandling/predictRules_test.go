@@ -0,0 +1,157 @@
+package eventhandling
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/template"
+)
+
+func TestGetPredictOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]predictOptions
+		wantErr bool
+	}{
+		{
+			name:    "objectives without predict option",
+			content: "objectives:\n  - sli: response_time_p95\n",
+			want:    map[string]predictOptions{},
+		},
+		{
+			name:    "predict option",
+			content: "objectives:\n  - sli: memory_saturation\n    predict:\n      lookback: 2h\n  - sli: response_time_p95\n",
+			want:    map[string]predictOptions{"memory_saturation": {Lookback: "2h"}},
+		},
+		{
+			name:    "invalid lookback",
+			content: "objectives:\n  - sli: memory_saturation\n    predict:\n      lookback: 1 hour\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid horizon",
+			content: "objectives:\n  - sli: memory_saturation\n    predict:\n      horizon: -4h\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := getPredictOptions(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if len(options) != len(tt.want) {
+				t.Fatalf("expected %d options, got %d", len(tt.want), len(options))
+			}
+			for sli, want := range tt.want {
+				if got, ok := options[sli]; !ok || *got != want {
+					t.Errorf("expected %+v for %s, got %+v", want, sli, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGetPredictiveExpr(t *testing.T) {
+	query := `sum(container_memory_working_set_bytes{namespace="sockshop-dev"})`
+	tests := []struct {
+		name            string
+		expr            string
+		options         *predictOptions
+		want            string
+		wantDescription string
+		wantErr         bool
+	}{
+		{
+			name:            "defaults",
+			expr:            query,
+			options:         &predictOptions{},
+			want:            `predict_linear((` + query + `)[1h:], 14400)>0.9`,
+			wantDescription: "memory_saturation is predicted to cross 0.9 within 4h based on its trend over the last 1h, {{ with query `(0.9 - (" + query + ")) / deriv((" + query + ")[1h:])` }}{{ $eta := . | first | value }}{{ if gt $eta 0.0 }}in about {{ humanizeDuration $eta }}{{ else }}now{{ end }}{{ end }}",
+		},
+		{
+			name:            "lookback and horizon",
+			expr:            query,
+			options:         &predictOptions{Lookback: "30m", Horizon: "90m"},
+			want:            `predict_linear((` + query + `)[30m:], 5400)>0.9`,
+			wantDescription: "memory_saturation is predicted to cross 0.9 within 90m based on its trend over the last 30m, {{ with query `(0.9 - (" + query + ")) / deriv((" + query + ")[30m:])` }}{{ $eta := . | first | value }}{{ if gt $eta 0.0 }}in about {{ humanizeDuration $eta }}{{ else }}now{{ end }}{{ end }}",
+		},
+		{
+			name:            "query with backquotes has no ETA",
+			expr:            "sum(x{a=`b`})",
+			options:         &predictOptions{},
+			want:            "predict_linear((sum(x{a=`b`}))[1h:], 14400)>0.9",
+			wantDescription: "memory_saturation is predicted to cross 0.9 within 4h based on its trend over the last 1h",
+		},
+		{name: "invalid lookback", expr: query, options: &predictOptions{Lookback: "1 hour"}, wantErr: true},
+		{name: "invalid horizon", expr: query, options: &predictOptions{Horizon: "4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, annotations, err := getPredictiveExpr("memory_saturation", tt.expr, ">0.9", tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if expr != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, expr)
+			}
+			if _, err := parser.ParseExpr(expr); err != nil {
+				t.Errorf("invalid expression %s: %s", expr, err.Error())
+			}
+			if annotations["descriptions"] != tt.wantDescription {
+				t.Errorf("expected %s, got %s", tt.wantDescription, annotations["descriptions"])
+			}
+			if annotations["summary"] != "memory_saturation" || annotations["predicted_value"] != "{{ $value }}" {
+				t.Errorf("unexpected annotations %v", annotations)
+			}
+		})
+	}
+}
+
+func TestPredictiveDescriptionTemplate(t *testing.T) {
+	_, annotations, err := getPredictiveExpr("memory_saturation", "sum(x)", ">0.9", &predictOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		eta  float64
+		want string
+	}{
+		{name: "crossing ahead", eta: 5400, want: ", in about 1h 30m 0s"},
+		{name: "crossing passed", eta: -60, want: ", now"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queries []string
+			queryFunc := func(ctx context.Context, q string, ts time.Time) (promql.Vector, error) {
+				queries = append(queries, q)
+				return promql.Vector{{Point: promql.Point{V: tt.eta}}}, nil
+			}
+			expander := template.NewTemplateExpander(context.Background(), annotations["descriptions"], "descriptions", nil, model.Now(), queryFunc, nil)
+			description, err := expander.Expand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(description, tt.want) {
+				t.Errorf("expected %s to end with %s", description, tt.want)
+			}
+			wantQuery := "(0.9 - (sum(x))) / deriv((sum(x))[1h:])"
+			if len(queries) != 1 || queries[0] != wantQuery {
+				t.Errorf("expected query %s, got %v", wantQuery, queries)
+			}
+		})
+	}
+}
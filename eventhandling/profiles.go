@@ -0,0 +1,153 @@
+package eventhandling
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// metricProfile describes the metrics of a common metric convention the default SLI queries are built from
+type metricProfile struct {
+	// requestsMetric counts the handled requests
+	requestsMetric string
+	// errorMatcher selects the failed requests of requestsMetric
+	errorMatcher *promlabels.Matcher
+	// serverErrorMatcher selects the requests of requestsMetric that failed because of the service, e.g. with a 5xx
+	// status
+	serverErrorMatcher *promlabels.Matcher
+	// durationBucket is the histogram of the request durations
+	durationBucket string
+	// durationUnit is the unit of durationBucket, milliseconds if not set. The response times are converted to
+	// milliseconds, the unit of the response time SLOs.
+	durationUnit time.Duration
+	// serviceMatchers select the requests of the service in the stage
+	serviceMatchers func(project string, stage string, service string) []*promlabels.Matcher
+	// scrapedByJob is set if the metrics are scraped by the scrape jobs of the service
+	scrapedByJob bool
+}
+
+// jobMatchers select the metrics scraped by the scrape job of the service in the stage
+func jobMatchers(project string, stage string, service string) []*promlabels.Matcher {
+	return []*promlabels.Matcher{
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "job", service+"-"+project+"-"+stage),
+	}
+}
+
+// metricProfiles are the profiles besides the default one, which is configured by METRIC_REQUESTS_TOTAL and
+// METRIC_RESPONSE_TIME_BUCKET. The workloads of the service meshes and ingresses are matched by the namespace of the
+// stage, so their metrics do not need to be scraped by the job of the service.
+var metricProfiles = map[string]*metricProfile{
+	"istio": {
+		requestsMetric:     "istio_requests_total",
+		errorMatcher:       promlabels.MustNewMatcher(promlabels.MatchRegexp, "response_code", "5.."),
+		serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchRegexp, "response_code", "5.."),
+		durationBucket:     "istio_request_duration_milliseconds_bucket",
+		serviceMatchers: func(project string, stage string, service string) []*promlabels.Matcher {
+			return []*promlabels.Matcher{
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "reporter", "destination"),
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "destination_workload", service),
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "destination_workload_namespace", project+"-"+stage),
+			}
+		},
+	},
+	"linkerd": {
+		requestsMetric:     "response_total",
+		errorMatcher:       promlabels.MustNewMatcher(promlabels.MatchEqual, "classification", "failure"),
+		serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchEqual, "classification", "failure"),
+		durationBucket:     "response_latency_ms_bucket",
+		serviceMatchers: func(project string, stage string, service string) []*promlabels.Matcher {
+			return []*promlabels.Matcher{
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "direction", "inbound"),
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "deployment", service),
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "namespace", project+"-"+stage),
+			}
+		},
+	},
+	"nginx-ingress": {
+		requestsMetric:     "nginx_ingress_controller_requests",
+		errorMatcher:       promlabels.MustNewMatcher(promlabels.MatchRegexp, "status", "5.."),
+		serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchRegexp, "status", "5.."),
+		durationBucket:     "nginx_ingress_controller_request_duration_seconds_bucket",
+		durationUnit:       time.Second,
+		serviceMatchers: func(project string, stage string, service string) []*promlabels.Matcher {
+			return []*promlabels.Matcher{
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "service", service),
+				promlabels.MustNewMatcher(promlabels.MatchEqual, "namespace", project+"-"+stage),
+			}
+		},
+	},
+	"grpc": {
+		requestsMetric: "grpc_server_handled_total",
+		errorMatcher:   promlabels.MustNewMatcher(promlabels.MatchNotEqual, "grpc_code", "OK"),
+		// the codes caused by the server, see https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+		serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchRegexp, "grpc_code", "Unknown|DeadlineExceeded|Unimplemented|Internal|Unavailable|DataLoss"),
+		durationBucket:     "grpc_server_handling_seconds_bucket",
+		durationUnit:       time.Second,
+		serviceMatchers:    jobMatchers,
+		scrapedByJob:       true,
+	},
+	"micrometer": {
+		requestsMetric:     "http_server_requests_seconds_count",
+		errorMatcher:       promlabels.MustNewMatcher(promlabels.MatchRegexp, "status", "5.."),
+		serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchRegexp, "status", "5.."),
+		durationBucket:     "http_server_requests_seconds_bucket",
+		durationUnit:       time.Second,
+		serviceMatchers:    jobMatchers,
+		scrapedByJob:       true,
+	},
+}
+
+// getMetricProfile returns the profile of the given name
+func getMetricProfile(config *utils.ServiceConfig, name string) (*metricProfile, error) {
+	if name == utils.MetricProfileDefault {
+		return &metricProfile{
+			requestsMetric:     config.RequestsMetric,
+			errorMatcher:       promlabels.MustNewMatcher(promlabels.MatchNotRegexp, "status", "2.."),
+			serverErrorMatcher: promlabels.MustNewMatcher(promlabels.MatchRegexp, "status", "5.."),
+			durationBucket:     config.ResponseTimeMetric,
+			serviceMatchers:    jobMatchers,
+			scrapedByJob:       true,
+		}, nil
+	}
+	profile, ok := metricProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown metric profile %s", name)
+	}
+	return profile, nil
+}
+
+// durationFactor returns the factor converting the durations of durationBucket to milliseconds, or an empty string if
+// they are in milliseconds
+func (p *metricProfile) durationFactor() string {
+	if p.durationUnit == 0 || p.durationUnit == time.Millisecond {
+		return ""
+	}
+	return strconv.FormatFloat(float64(p.durationUnit)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// durationCountMetric returns the counter of the observations of durationBucket
+func (p *metricProfile) durationCountMetric() string {
+	return strings.TrimSuffix(p.durationBucket, "_bucket") + "_count"
+}
+
+// bucketMatcher selects the bucket of durationBucket holding the durations up to the given one, e.g. le="0.5" for
+// 500ms if the durations are in seconds. The clients format the boundaries differently, so trailing zeros are
+// accepted, e.g. le="1.0" for 1s.
+func (p *metricProfile) bucketMatcher(duration time.Duration) *promlabels.Matcher {
+	unit := p.durationUnit
+	if unit == 0 {
+		unit = time.Millisecond
+	}
+	boundary := strconv.FormatFloat(float64(duration)/float64(unit), 'f', -1, 64)
+	pattern := regexp.QuoteMeta(boundary) + `(\.0+)?`
+	if strings.Contains(boundary, ".") {
+		pattern = regexp.QuoteMeta(boundary) + "0*"
+	}
+	return promlabels.MustNewMatcher(promlabels.MatchRegexp, "le", pattern)
+}
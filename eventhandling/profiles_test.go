@@ -0,0 +1,177 @@
+package eventhandling
+
+import (
+	"testing"
+	"time"
+
+	keptn "github.com/keptn/go-utils/pkg/lib"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+func TestDurationFactor(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile *metricProfile
+		want    string
+	}{
+		{name: "default unit", profile: &metricProfile{}, want: ""},
+		{name: "milliseconds", profile: &metricProfile{durationUnit: time.Millisecond}, want: ""},
+		{name: "seconds", profile: &metricProfile{durationUnit: time.Second}, want: "1000"},
+		{name: "microseconds", profile: &metricProfile{durationUnit: time.Microsecond}, want: "0.001"},
+		{name: "istio", profile: metricProfiles["istio"], want: ""},
+		{name: "linkerd", profile: metricProfiles["linkerd"], want: ""},
+		{name: "nginx-ingress", profile: metricProfiles["nginx-ingress"], want: "1000"},
+		{name: "grpc", profile: metricProfiles["grpc"], want: "1000"},
+		{name: "micrometer", profile: metricProfiles["micrometer"], want: "1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.durationFactor(); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBucketMatcher(t *testing.T) {
+	seconds := &metricProfile{durationUnit: time.Second}
+	milliseconds := &metricProfile{}
+	tests := []struct {
+		name      string
+		profile   *metricProfile
+		duration  time.Duration
+		want      string
+		matches   []string
+		noMatches []string
+	}{
+		{
+			name:      "fraction of seconds",
+			profile:   seconds,
+			duration:  500 * time.Millisecond,
+			want:      `le=~"0\\.50*"`,
+			matches:   []string{"0.5", "0.50", "0.500"},
+			noMatches: []string{"0.05", "0.55", "5", "0"},
+		},
+		{
+			name:      "whole seconds",
+			profile:   seconds,
+			duration:  time.Second,
+			want:      `le=~"1(\\.0+)?"`,
+			matches:   []string{"1", "1.0", "1.00"},
+			noMatches: []string{"10", "1.5", "0.1"},
+		},
+		{
+			name:      "milliseconds",
+			profile:   milliseconds,
+			duration:  500 * time.Millisecond,
+			want:      `le=~"500(\\.0+)?"`,
+			matches:   []string{"500", "500.0"},
+			noMatches: []string{"0.5", "5000", "50"},
+		},
+		{
+			name:      "fraction of milliseconds",
+			profile:   milliseconds,
+			duration:  2500 * time.Microsecond,
+			want:      `le=~"2\\.50*"`,
+			matches:   []string{"2.5", "2.50"},
+			noMatches: []string{"25", "2.05"},
+		},
+		{
+			name:      "istio buckets",
+			profile:   metricProfiles["istio"],
+			duration:  2 * time.Second,
+			want:      `le=~"2000(\\.0+)?"`,
+			matches:   []string{"2000", "2000.0"},
+			noMatches: []string{"2", "200"},
+		},
+		{
+			name:      "linkerd buckets",
+			profile:   metricProfiles["linkerd"],
+			duration:  500 * time.Millisecond,
+			want:      `le=~"500(\\.0+)?"`,
+			matches:   []string{"500"},
+			noMatches: []string{"0.5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher := tt.profile.bucketMatcher(tt.duration)
+			if matcher.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, matcher.String())
+			}
+			for _, le := range tt.matches {
+				if !matcher.Matches(le) {
+					t.Errorf("expected %s to match le=%s", matcher.String(), le)
+				}
+			}
+			for _, le := range tt.noMatches {
+				if matcher.Matches(le) {
+					t.Errorf("expected %s not to match le=%s", matcher.String(), le)
+				}
+			}
+		})
+	}
+}
+
+func TestMetricProfileSelection(t *testing.T) {
+	config := &utils.ServiceConfig{
+		QueryRange:         "3m",
+		MetricProfile:      utils.MetricProfileDefault,
+		RequestsMetric:     "http_requests_total",
+		ResponseTimeMetric: "http_response_time_milliseconds_bucket",
+	}
+	tests := []struct {
+		name      string
+		resources []string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name: "default profile",
+			want: `histogram_quantile(0.95,sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev"}[3m]))by(le))`,
+		},
+		{
+			name:      "profile of the project",
+			resources: []string{"profile: istio"},
+			want:      `histogram_quantile(0.95,sum(rate(istio_request_duration_milliseconds_bucket{destination_workload="carts",destination_workload_namespace="sockshop-dev",reporter="destination"}[3m]))by(le))`,
+		},
+		{
+			name:      "profile of the service replaces the one of the project",
+			resources: []string{"profile: istio", "indicators: {}", "profile: nginx-ingress"},
+			want:      `histogram_quantile(0.95,sum(rate(nginx_ingress_controller_request_duration_seconds_bucket{namespace="sockshop-dev",service="carts"}[3m]))by(le))*1000`,
+		},
+		{
+			name:      "linkerd",
+			resources: []string{"profile: linkerd"},
+			want:      `histogram_quantile(0.95,sum(rate(response_latency_ms_bucket{deployment="carts",direction="inbound",namespace="sockshop-dev"}[3m]))by(le))`,
+		},
+		{
+			name:      "unknown profile",
+			resources: []string{"profile: envoy"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sliConfig := &sliConfiguration{queries: map[string]customQuery{}, profile: config.MetricProfile}
+			for _, resource := range tt.resources {
+				addResourceQueries(sliConfig, resource, "test", keptn.NewLogger("", "", "prometheus-service"))
+			}
+			profile, err := getMetricProfile(config, sliConfig.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			query, err := getDefaultResponseTimeQuery(config, profile, "sockshop", "dev", "carts", nil, "95")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, query)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package eventhandling
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// legacyVariablePattern matches the $VARIABLE placeholders of queries that are not written as template. Variables
+// are matched as a whole, so $SERVICE is not replaced within $SERVICE_NAME.
+var legacyVariablePattern = regexp.MustCompile(`\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// queryTemplateData holds the variables of a custom SLI query template
+type queryTemplateData struct {
+	Project string
+	Stage   string
+	Service string
+	// DeploymentType is the deployment strategy of the stage, e.g. direct or blue_green_service
+	DeploymentType string
+	// Filters are the filters of the SLO file
+	Filters map[string]string
+	// Duration is the range of the query, e.g. 180s
+	Duration string
+	// Start and End are the evaluation window as PromQL expressions relative to the time a rule is evaluated, e.g.
+	// (time() - 180) and time(). Timestamps fixed when the rules are generated would drift from the evaluations.
+	Start string
+	End   string
+	// Job is the scrape job of the service in the stage, which is also the job of the primary deployment
+	Job        string
+	PrimaryJob string
+	CanaryJob  string
+}
+
+// queryTemplateFuncs are the helper functions available in query templates
+var queryTemplateFuncs = template.FuncMap{
+	// quote renders a PromQL string literal, e.g. for label values
+	"quote": func(value string) string {
+		return fmt.Sprintf("%q", value)
+	},
+	// regexQuote escapes the regular expression metacharacters of a value
+	"regexQuote": regexp.QuoteMeta,
+	"join": func(sep string, values []string) string {
+		return strings.Join(values, sep)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// filterKeys returns the keys of the filters in a stable order
+	"filterKeys": func(filters map[string]string) []string {
+		keys := make([]string, 0, len(filters))
+		for key := range filters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	},
+}
+
+// newQueryTemplateData returns the variables of the query templates of a service in a stage
+func newQueryTemplateData(config *utils.ServiceConfig, project string, stage string, service string, deploymentType string, filters map[string]string) (*queryTemplateData, error) {
+	duration, err := model.ParseDuration(config.QueryRange)
+	if err != nil {
+		return nil, err
+	}
+	if filters == nil {
+		filters = map[string]string{}
+	}
+	job := service + "-" + project + "-" + stage
+	return &queryTemplateData{
+		Project:        project,
+		Stage:          stage,
+		Service:        service,
+		DeploymentType: deploymentType,
+		Filters:        filters,
+		Duration:       config.QueryRange,
+		Start:          fmt.Sprintf("(time() - %d)", int64(time.Duration(duration).Seconds())),
+		End:            "time()",
+		Job:            job,
+		PrimaryJob:     job,
+		CanaryJob:      job + "-canary",
+	}, nil
+}
+
+// renderQuery renders a custom SLI query. Queries containing {{ are Go templates, all others use the $VARIABLE
+// placeholders of earlier versions. Unknown variables are reported as error instead of producing invalid PromQL.
+func renderQuery(query string, data *queryTemplateData) (string, error) {
+	if !strings.Contains(query, "{{") {
+		return renderLegacyQuery(query, data)
+	}
+	funcs := template.FuncMap{
+		// filter returns the value of a filter and fails for unknown filters, unlike index
+		"filter": func(key string) (string, error) {
+			value, ok := data.Filters[key]
+			if !ok {
+				return "", fmt.Errorf("unknown filter %s", key)
+			}
+			return value, nil
+		},
+	}
+	tmpl, err := template.New("query").Funcs(queryTemplateFuncs).Funcs(funcs).Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query template: %s", err.Error())
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("could not render query template: %s", err.Error())
+	}
+	return strings.TrimSpace(rendered.String()), nil
+}
+
+// renderLegacyQuery replaces $PROJECT, $STAGE, $SERVICE, $DURATION_SECONDS and the filter keys, in upper or lower
+// case. Quotes are removed from filter values as in earlier versions.
+func renderLegacyQuery(query string, data *queryTemplateData) (string, error) {
+	variables := map[string]string{
+		"PROJECT":          data.Project,
+		"STAGE":            data.Stage,
+		"SERVICE":          data.Service,
+		"DEPLOYMENT_TYPE":  data.DeploymentType,
+		"DURATION_SECONDS": data.Duration,
+	}
+	for key, value := range data.Filters {
+		sanitizedValue := strings.Replace(strings.Replace(value, "'", "", -1), "\"", "", -1)
+		variables[key] = sanitizedValue
+		variables[strings.ToUpper(key)] = sanitizedValue
+	}
+
+	var unknown []string
+	rendered := legacyVariablePattern.ReplaceAllStringFunc(query, func(match string) string {
+		name := strings.TrimPrefix(match, "$")
+		if value, ok := variables[name]; ok {
+			return value
+		}
+		if value, ok := variables[strings.ToUpper(name)]; ok && name == strings.ToLower(name) {
+			return value
+		}
+		unknown = append(unknown, match)
+		return match
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown variable(s) %s in query", strings.Join(unknown, ", "))
+	}
+	return rendered, nil
+}
@@ -0,0 +1,135 @@
+package eventhandling
+
+import (
+	"testing"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+func TestRenderQuery(t *testing.T) {
+	data, err := newQueryTemplateData(&utils.ServiceConfig{QueryRange: "180s"}, "sockshop", "dev", "carts", "direct", map[string]string{
+		"handler": "ItemsController.addToCart",
+		"quoted":  `'a"b'`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "legacy placeholders",
+			query: "sum(rate(http_requests_total{job='$SERVICE-$PROJECT-$STAGE'}[$DURATION_SECONDS]))",
+			want:  "sum(rate(http_requests_total{job='carts-sockshop-dev'}[180s]))",
+		},
+		{
+			name:  "legacy deployment type",
+			query: "up{strategy='$DEPLOYMENT_TYPE'}",
+			want:  "up{strategy='direct'}",
+		},
+		{
+			name:  "legacy filters in upper and lower case",
+			query: "x{handler='$HANDLER',h2='$handler'}",
+			want:  "x{handler='ItemsController.addToCart',h2='ItemsController.addToCart'}",
+		},
+		{
+			name:  "legacy filter values without quotes",
+			query: "x{q='$QUOTED'}",
+			want:  "x{q='ab'}",
+		},
+		{
+			name:    "legacy variables are matched as a whole",
+			query:   "x{s='$SERVICE_NAME'}",
+			wantErr: true,
+		},
+		{
+			name:    "unknown legacy variable",
+			query:   "x{a='$UNKNOWN'}",
+			wantErr: true,
+		},
+		{
+			name:    "mixed case legacy variable",
+			query:   "x{a='$Service'}",
+			wantErr: true,
+		},
+		{
+			name:  "template variables",
+			query: `sum(rate(http_requests_total{job="{{ .Job }}"}[{{ .Duration }}])) / sum(rate(http_requests_total{job="{{ .CanaryJob }}"}[{{ .Duration }}]))`,
+			want:  `sum(rate(http_requests_total{job="carts-sockshop-dev"}[180s])) / sum(rate(http_requests_total{job="carts-sockshop-dev-canary"}[180s]))`,
+		},
+		{
+			name:  "template filter helpers",
+			query: `x{handler={{ filter "handler" | quote }},re=~"{{ regexQuote .Filters.handler }}"}`,
+			want:  `x{handler="ItemsController.addToCart",re=~"ItemsController\.addToCart"}`,
+		},
+		{
+			name:  "template filter keys in stable order",
+			query: `{{ join "," (filterKeys .Filters) }} {{ upper .Stage }}`,
+			want:  "handler,quoted DEV",
+		},
+		{
+			name:  "template evaluation window",
+			query: `count_over_time(x[{{ .Duration }}]) and timestamp(x) >= {{ .Start }} and timestamp(x) <= {{ .End }}`,
+			want:  `count_over_time(x[180s]) and timestamp(x) >= (time() - 180) and timestamp(x) <= time()`,
+		},
+		{
+			name:  "template output is trimmed",
+			query: "  {{ .Service }}\n",
+			want:  "carts",
+		},
+		{
+			name:    "unknown template variable",
+			query:   "x{a='{{ .Unknown }}'}",
+			wantErr: true,
+		},
+		{
+			name:    "unknown filter",
+			query:   `x{a={{ filter "unknown" | quote }}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown filter key",
+			query:   `x{a="{{ .Filters.unknown }}"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid template",
+			query:   "x{a='{{ .Job '}",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderQuery(tt.query, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewQueryTemplateData(t *testing.T) {
+	if _, err := newQueryTemplateData(&utils.ServiceConfig{QueryRange: "3 minutes"}, "sockshop", "dev", "carts", "direct", nil); err == nil {
+		t.Error("expected an error for an invalid query range")
+	}
+	data, err := newQueryTemplateData(&utils.ServiceConfig{QueryRange: "5m"}, "sockshop", "dev", "carts", "direct", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Start != "(time() - 300)" || data.End != "time()" {
+		t.Errorf("unexpected evaluation window %s, %s", data.Start, data.End)
+	}
+	if data.Filters == nil {
+		t.Error("expected empty filters")
+	}
+	if data.Job != "carts-sockshop-dev" || data.PrimaryJob != data.Job || data.CanaryJob != "carts-sockshop-dev-canary" {
+		t.Errorf("unexpected jobs %s, %s, %s", data.Job, data.PrimaryJob, data.CanaryJob)
+	}
+}
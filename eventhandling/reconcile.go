@@ -0,0 +1,247 @@
+package eventhandling
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	configutils "github.com/keptn/go-utils/pkg/api/utils"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+const (
+	driftKindScrapeJob = "scrape_job"
+	driftKindRuleGroup = "rule_group"
+)
+
+// configChange is a scrape job or rule group of the Prometheus configuration that differs from the desired state
+type configChange struct {
+	kind   string
+	name   string
+	action string
+}
+
+func (c configChange) String() string {
+	kind := "scrape job"
+	if c.kind == driftKindRuleGroup {
+		kind = "rule group"
+	}
+	// the state is described from the perspective of the current configuration
+	state := "modified"
+	if c.action == changeAdded {
+		state = "missing"
+	}
+	return fmt.Sprintf("%s %s (%s)", kind, c.name, state)
+}
+
+// ReconcileConfig periodically rebuilds the managed scrape jobs and rules of all monitored services and repairs the
+// Prometheus config map if it differs, e.g., after a manual edit or a Helm upgrade
+func ReconcileConfig(logger keptn.LoggerInterface) {
+	for {
+		interval := minReloadCheckInterval
+		config, err := utils.GetConfig()
+		if err == nil && config.ReconcileInterval > 0 {
+			interval = config.ReconcileInterval
+		}
+		time.Sleep(interval)
+		if err != nil || config.ReconcileInterval == 0 {
+			continue
+		}
+
+		result, err := syncMonitoringConfig(nil, "repair drift", logger)
+		if err != nil {
+			reconcileRunsTotal.WithLabelValues("error").Inc()
+			logger.Error("Could not reconcile the Prometheus configuration: " + err.Error())
+			continue
+		}
+		reconcileRunsTotal.WithLabelValues("success").Inc()
+		reconcileLastSuccess.SetToCurrentTime()
+		if !result.applied {
+			reconcileDriftDetected.Set(0)
+			continue
+		}
+		reconcileDriftDetected.Set(1)
+		reconcileRepairsTotal.Inc()
+		for _, change := range result.changes {
+			reconcileDriftTotal.WithLabelValues(change.kind).Inc()
+		}
+		logger.Info("Repaired drift of the Prometheus configuration")
+	}
+}
+
+// syncResult is the outcome of a comparison of the Prometheus config map with the desired configuration
+type syncResult struct {
+	desired *monitoringConfig
+	changes []configChange
+	// applied is set if the desired configuration differed and has been applied
+	applied bool
+}
+
+// syncMonitoringConfig compares the Prometheus config map with the desired configuration of the monitored services of
+// the given projects, or of all projects if projects is nil, and applies the desired configuration if they differ
+func syncMonitoringConfig(projects []string, reason string, logger keptn.LoggerInterface) (*syncResult, error) {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return nil, err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	current, err := api.CoreV1().ConfigMaps(promConfig.Namespace).Get(promConfig.ConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		logger.Debug("Prometheus config map not found, skipping the update of the managed configuration")
+		return &syncResult{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	desiredConfig, err := getDesiredMonitoringConfig(projects, logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(desiredConfig.stages) == 0 {
+		return &syncResult{desired: desiredConfig}, nil
+	}
+
+	planned := current.DeepCopy()
+	if err := applyMonitoringConfig(planned, desiredConfig, promConfig); err != nil {
+		return nil, err
+	}
+	if planned.Data[promConfig.ConfigFileKey] == current.Data[promConfig.ConfigFileKey] &&
+		planned.Data[promConfig.RulesFileKey] == current.Data[promConfig.RulesFileKey] {
+		return &syncResult{desired: desiredConfig}, nil
+	}
+
+	changes, err := getConfigChanges(current.Data, planned.Data, desiredConfig, promConfig)
+	if err != nil {
+		return nil, err
+	}
+	var descriptions []string
+	for _, change := range changes {
+		descriptions = append(descriptions, change.String())
+	}
+	if len(descriptions) == 0 {
+		// e.g. the generated configuration is only formatted differently
+		descriptions = append(descriptions, "formatting of the managed configuration")
+	}
+	logger.Info("Prometheus configuration differs from the desired state: " + strings.Join(descriptions, ", "))
+
+	if err := applyPrometheusConfig(desiredConfig, uuid.New().String(), reason+" of "+strings.Join(descriptions, ", "), logger); err != nil {
+		return nil, err
+	}
+	return &syncResult{desired: desiredConfig, changes: changes, applied: true}, nil
+}
+
+// monitoredService is a service in a stage for which the prometheus-service has stored a monitoring configuration
+type monitoredService struct {
+	project string
+	stage   string
+	service string
+}
+
+// getMonitoredServices returns the services of the given projects, or of all projects if projects is nil, for which
+// the prometheus-service has stored a monitoring configuration in the configuration-service in any stage
+func getMonitoredServices(projects []string) ([]monitoredService, error) {
+	projectHandler := configutils.NewProjectHandler(getConfigurationServiceURL())
+	resourceHandler := configutils.NewResourceHandler(getConfigurationServiceURL())
+	allProjects, err := projectHandler.GetAllProjects()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve projects: %s", err.Error())
+	}
+
+	var monitored []monitoredService
+	for _, project := range allProjects {
+		if projects != nil && !containsString(projects, project.ProjectName) {
+			continue
+		}
+		var services []string
+		for _, stage := range project.Stages {
+			for _, service := range stage.Services {
+				if containsString(services, service.ServiceName) {
+					continue
+				}
+				resource, err := resourceHandler.GetServiceResource(project.ProjectName, stage.StageName, service.ServiceName, scrapeConfigResourceURI)
+				if err == nil && resource != nil && resource.ResourceContent != "" {
+					services = append(services, service.ServiceName)
+				}
+			}
+		}
+		for _, stage := range project.Stages {
+			for _, service := range stage.Services {
+				if containsString(services, service.ServiceName) {
+					monitored = append(monitored, monitoredService{project: project.ProjectName, stage: stage.StageName, service: service.ServiceName})
+				}
+			}
+		}
+	}
+	return monitored, nil
+}
+
+// getDesiredMonitoringConfig builds the scrape jobs and rules of the monitored services of the given projects, or of
+// all projects if projects is nil. A project whose configuration cannot be built, e.g. because the
+// configuration-service is unavailable, is left out, so its current scrape jobs and rules are kept.
+func getDesiredMonitoringConfig(projects []string, logger keptn.LoggerInterface) (*monitoringConfig, error) {
+	monitored, err := getMonitoredServices(projects)
+	if err != nil {
+		return nil, err
+	}
+	var projectNames []string
+	servicesByProject := map[string][]string{}
+	for _, m := range monitored {
+		if !containsString(projectNames, m.project) {
+			projectNames = append(projectNames, m.project)
+		}
+		if !containsString(servicesByProject[m.project], m.service) {
+			servicesByProject[m.project] = append(servicesByProject[m.project], m.service)
+		}
+	}
+
+	desiredConfig := &monitoringConfig{}
+	for _, project := range projectNames {
+		eventData := &keptn.ConfigureMonitoringEventData{Project: project, Type: "prometheus"}
+		keptnHandler, err := newKeptnHandler(eventData, uuid.New().String())
+		if err != nil {
+			logger.Error(fmt.Sprintf("Could not build the monitoring configuration of project %s, keeping its current configuration: %s", project, err.Error()))
+			continue
+		}
+		projectConfig, err := getMonitoringConfig(*eventData, &configureOptions{Services: servicesByProject[project]}, logger, keptnHandler)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Could not build the monitoring configuration of project %s, keeping its current configuration: %s", project, err.Error()))
+			continue
+		}
+		desiredConfig.stages = append(desiredConfig.stages, projectConfig.stages...)
+	}
+	return desiredConfig, nil
+}
+
+// getConfigChanges lists the scrape jobs and rule groups that differ between the current and the desired
+// configuration
+func getConfigChanges(current map[string]string, planned map[string]string, desiredConfig *monitoringConfig, promConfig *utils.PrometheusConfig) ([]configChange, error) {
+	jobs, err := diffScrapeJobs(current[promConfig.ConfigFileKey], planned[promConfig.ConfigFileKey], desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := diffRuleGroups(current[promConfig.RulesFileKey], planned[promConfig.RulesFileKey], desiredConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []configChange
+	for _, job := range jobs {
+		if job.Action != changeUnchanged {
+			changes = append(changes, configChange{kind: driftKindScrapeJob, name: job.Job, action: job.Action})
+		}
+	}
+	for _, group := range groups {
+		if group.Action != changeUnchanged {
+			changes = append(changes, configChange{kind: driftKindRuleGroup, name: group.Group, action: group.Action})
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,88 @@
+package eventhandling
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	keptn "github.com/keptn/go-utils/pkg/lib"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// minReloadCheckInterval is used to wait for a valid configuration if the check of the configuration file is disabled
+const minReloadCheckInterval = time.Minute
+
+// reloadConfig reads the service configuration again. If the configuration is invalid, the current one is kept.
+func reloadConfig(logger keptn.LoggerInterface) (*utils.ServiceConfig, error) {
+	config, err := utils.LoadConfig()
+	if err != nil {
+		logger.Error("Could not reload the configuration, keeping the current one: " + err.Error())
+		return nil, err
+	}
+	logger.Info("Reloaded the configuration:\n" + config.Summary())
+	return config, nil
+}
+
+// WatchConfig reloads the service configuration on SIGHUP and whenever the configuration file changes
+func WatchConfig(logger keptn.LoggerInterface) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	lastModified := getConfigFileModTime()
+	for {
+		interval := minReloadCheckInterval
+		config, err := utils.GetConfig()
+		if err == nil && config.ReloadInterval > 0 {
+			interval = config.ReloadInterval
+		}
+
+		select {
+		case <-signals:
+			logger.Info("Received SIGHUP, reloading the configuration")
+			reloadConfig(logger)
+			lastModified = getConfigFileModTime()
+		case <-time.After(interval):
+			if err == nil && config.ReloadInterval == 0 {
+				continue
+			}
+			if modified := getConfigFileModTime(); !modified.Equal(lastModified) {
+				logger.Info("Configuration file " + utils.GetConfigFile() + " changed, reloading the configuration")
+				reloadConfig(logger)
+				lastModified = modified
+			}
+		}
+	}
+}
+
+func getConfigFileModTime() time.Time {
+	path := utils.GetConfigFile()
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// HandleConfigReload reloads the service configuration and returns the new settings
+func HandleConfigReload(rw http.ResponseWriter, req *http.Request) {
+	logger := keptn.NewLogger("", "", "prometheus-service")
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdminRequest(rw, req) {
+		return
+	}
+	config, err := reloadConfig(logger)
+	if err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, map[string]string{"message": "configuration reloaded", "settings": config.Summary()})
+}
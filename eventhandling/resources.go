@@ -0,0 +1,102 @@
+package eventhandling
+
+import (
+	"fmt"
+
+	"github.com/keptn/go-utils/pkg/api/models"
+	configutils "github.com/keptn/go-utils/pkg/api/utils"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+)
+
+const scrapeConfigResourceURI = "prometheus/scrape-config.yaml"
+const alertingRulesResourceURI = "prometheus/alerts.yaml"
+
+type scrapeConfigResource struct {
+	ScrapeConfigs []*scrapeJob `json:"scrape_configs" yaml:"scrape_configs"`
+}
+
+// resourceVersion is the version of the configuration-service in which the Prometheus configuration of a service in a
+// stage has been stored
+type resourceVersion struct {
+	Stage   string `json:"stage"`
+	Service string `json:"service"`
+	Version string `json:"version"`
+}
+
+// storeMonitoringResources commits the generated scrape jobs and alerting rules of each stage as resources of the
+// service to the configuration-service and returns the version of each commit. The alerting rules of a service
+// without generated rules are removed, so a stale rule file does not outlive its SLOs.
+func storeMonitoringResources(desiredConfig *monitoringConfig, logger keptn.LoggerInterface) ([]*resourceVersion, error) {
+	resourceHandler := configutils.NewResourceHandler(getConfigurationServiceURL())
+
+	var versions []*resourceVersion
+	for _, stageConfig := range desiredConfig.stages {
+		if stageConfig.alertingGroup == nil {
+			if err := deleteAlertingRulesResource(resourceHandler, stageConfig, logger); err != nil {
+				return versions, err
+			}
+		}
+		resources, err := getMonitoringResources(stageConfig)
+		if err != nil {
+			return versions, err
+		}
+		commitID, err := resourceHandler.CreateServiceResources(stageConfig.project, stageConfig.stage, stageConfig.service, resources)
+		if err != nil {
+			return versions, fmt.Errorf("could not store Prometheus configuration of service %s in stage %s: %s", stageConfig.service, stageConfig.stage, err.Error())
+		}
+		logger.Info(fmt.Sprintf("Stored Prometheus configuration of service %s in stage %s with version %s", stageConfig.service, stageConfig.stage, commitID))
+		versions = append(versions, &resourceVersion{Stage: stageConfig.stage, Service: stageConfig.service, Version: commitID})
+	}
+	return versions, nil
+}
+
+// deleteAlertingRulesResource removes the alerting rules stored for a service in a stage, if there are any
+func deleteAlertingRulesResource(resourceHandler *configutils.ResourceHandler, stageConfig *stageMonitoringConfig, logger keptn.LoggerInterface) error {
+	_, err := resourceHandler.GetServiceResource(stageConfig.project, stageConfig.stage, stageConfig.service, alertingRulesResourceURI)
+	if err == configutils.ResourceNotFoundError {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read alerting rules of service %s in stage %s: %s", stageConfig.service, stageConfig.stage, err.Error())
+	}
+	if err := resourceHandler.DeleteServiceResource(stageConfig.project, stageConfig.stage, stageConfig.service, alertingRulesResourceURI); err != nil {
+		return fmt.Errorf("could not remove alerting rules of service %s in stage %s: %s", stageConfig.service, stageConfig.stage, err.Error())
+	}
+	logger.Info(fmt.Sprintf("Removed alerting rules of service %s in stage %s, no rules are generated", stageConfig.service, stageConfig.stage))
+	return nil
+}
+
+func getMonitoringResources(stageConfig *stageMonitoringConfig) ([]*models.Resource, error) {
+	scrapeConfigYAML, err := yaml.Marshal(scrapeConfigResource{ScrapeConfigs: stageConfig.scrapeJobs})
+	if err != nil {
+		return nil, err
+	}
+	resources := []*models.Resource{
+		{
+			ResourceURI:     stringPtr(scrapeConfigResourceURI),
+			ResourceContent: string(scrapeConfigYAML),
+		},
+	}
+
+	if stageConfig.alertingGroup != nil {
+		alertingRulesYAML, err := yaml.Marshal(alertingRules{
+			Groups: []*alertingGroup{
+				{
+					Name:  stageConfig.alertingGroup.name,
+					Rules: stageConfig.alertingGroup.rules,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, &models.Resource{
+			ResourceURI:     stringPtr(alertingRulesResourceURI),
+			ResourceContent: string(alertingRulesYAML),
+		})
+	}
+	return resources, nil
+}
+
+func stringPtr(s string) *string { return &s }
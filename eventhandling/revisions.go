@@ -0,0 +1,358 @@
+package eventhandling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// revisionConfigMapSuffix is appended to the name of the Prometheus config map, followed by the revision number
+const revisionConfigMapSuffix = "-rev-"
+const revisionLabel = "prometheus-service.keptn.sh/config-revision"
+const revisionKeptnContextAnnotation = "prometheus-service.keptn.sh/keptn-context"
+const revisionReasonAnnotation = "prometheus-service.keptn.sh/reason"
+const revisionCreatedAnnotation = "prometheus-service.keptn.sh/created"
+
+// defaultConfigRevisions and defaultReadyTimeout are used if the service configuration cannot be read
+const defaultConfigRevisions = 10
+const defaultReadyTimeout = 2 * time.Minute
+
+// configRevision describes a stored revision of the Prometheus configuration
+type configRevision struct {
+	Revision     int    `json:"revision"`
+	KeptnContext string `json:"keptnContext,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Created      string `json:"created,omitempty"`
+}
+
+type rollbackResponse struct {
+	Revision int    `json:"revision"`
+	Message  string `json:"message"`
+}
+
+// storeConfigRevision stores the content of the Prometheus config map as a new numbered revision
+func storeConfigRevision(api kubernetes.Interface, cmPrometheus *v1.ConfigMap, keptnContext string, reason string) (int, error) {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := listConfigRevisions(api)
+	if err != nil {
+		return 0, err
+	}
+	revision := 1
+	if len(revisions) > 0 {
+		revision = revisions[len(revisions)-1].Revision + 1
+	}
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      getRevisionConfigMapName(promConfig, revision),
+			Namespace: promConfig.Namespace,
+			Labels: map[string]string{
+				revisionLabel:        strconv.Itoa(revision),
+				utils.ManagedByLabel: "prometheus-service",
+			},
+			Annotations: map[string]string{
+				revisionKeptnContextAnnotation: keptnContext,
+				revisionReasonAnnotation:       reason,
+				revisionCreatedAnnotation:      time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string]string{
+			promConfig.ConfigFileKey: cmPrometheus.Data[promConfig.ConfigFileKey],
+			promConfig.RulesFileKey:  cmPrometheus.Data[promConfig.RulesFileKey],
+		},
+	}
+	if _, err := api.CoreV1().ConfigMaps(promConfig.Namespace).Create(cm); err != nil {
+		return 0, err
+	}
+	return revision, nil
+}
+
+// pruneConfigRevisions removes the oldest revisions exceeding the retention count
+func pruneConfigRevisions(api kubernetes.Interface) error {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return err
+	}
+	revisions, err := listConfigRevisions(api)
+	if err != nil {
+		return err
+	}
+	retention := getConfigRevisionRetention()
+	for i := 0; i < len(revisions)-retention; i++ {
+		name := getRevisionConfigMapName(promConfig, revisions[i].Revision)
+		if err := api.CoreV1().ConfigMaps(promConfig.Namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("could not delete revision %d of the Prometheus configuration: %s", revisions[i].Revision, err.Error())
+		}
+	}
+	return nil
+}
+
+// updateConfigMapWithRevision stores the content of the Prometheus config map as a new revision before the config
+// map is updated, so no configuration is applied without a revision that can be rolled back. The revision is removed
+// again if the update fails. The error of the update is returned as is, so conflicts can be retried.
+func updateConfigMapWithRevision(api kubernetes.Interface, cmPrometheus *v1.ConfigMap, keptnContext string, reason string) (*v1.ConfigMap, int, error) {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return nil, 0, err
+	}
+	revision, err := storeConfigRevision(api, cmPrometheus, keptnContext, reason)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not store revision of the Prometheus configuration: %s", err.Error())
+	}
+	updated, err := api.CoreV1().ConfigMaps(promConfig.Namespace).Update(cmPrometheus)
+	if err != nil {
+		if deleteErr := api.CoreV1().ConfigMaps(promConfig.Namespace).Delete(getRevisionConfigMapName(promConfig, revision), &metav1.DeleteOptions{}); deleteErr != nil && !apierrors.IsNotFound(deleteErr) {
+			return nil, 0, fmt.Errorf("%s, and the revision %d of the configuration could not be removed: %s", err.Error(), revision, deleteErr.Error())
+		}
+		return nil, 0, err
+	}
+	return updated, revision, nil
+}
+
+// ensureInitialConfigRevision stores the current configuration as first revision, so the very first change
+// can be rolled back as well
+func ensureInitialConfigRevision(api kubernetes.Interface, cmPrometheus *v1.ConfigMap) error {
+	revisions, err := listConfigRevisions(api)
+	if err != nil || len(revisions) > 0 {
+		return err
+	}
+	_, err = storeConfigRevision(api, cmPrometheus, "", "initial configuration")
+	return err
+}
+
+// listConfigRevisions returns the stored revisions sorted by their number
+func listConfigRevisions(api kubernetes.Interface) ([]*configRevision, error) {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return nil, err
+	}
+	cms, err := api.CoreV1().ConfigMaps(promConfig.Namespace).List(metav1.ListOptions{LabelSelector: revisionLabel})
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*configRevision
+	for _, cm := range cms.Items {
+		revision, err := strconv.Atoi(cm.Labels[revisionLabel])
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, &configRevision{
+			Revision:     revision,
+			KeptnContext: cm.Annotations[revisionKeptnContextAnnotation],
+			Reason:       cm.Annotations[revisionReasonAnnotation],
+			Created:      cm.Annotations[revisionCreatedAnnotation],
+		})
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision < revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// rollbackPrometheusConfig restores the given revision of the Prometheus configuration and restarts Prometheus
+func rollbackPrometheusConfig(revision int, keptnContext string, logger keptn.LoggerInterface) error {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+
+	prometheusConfigMutex.Lock()
+	defer prometheusConfigMutex.Unlock()
+
+	cmRevision, err := api.CoreV1().ConfigMaps(promConfig.Namespace).Get(getRevisionConfigMapName(promConfig, revision), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not retrieve revision %d of the Prometheus configuration: %s", revision, err.Error())
+	}
+
+	var cmPrometheus *v1.ConfigMap
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cmPrometheus, err = api.CoreV1().ConfigMaps(promConfig.Namespace).Get(promConfig.ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if cmPrometheus.Data == nil {
+			cmPrometheus.Data = map[string]string{}
+		}
+		cmPrometheus.Data[promConfig.ConfigFileKey] = cmRevision.Data[promConfig.ConfigFileKey]
+		cmPrometheus.Data[promConfig.RulesFileKey] = cmRevision.Data[promConfig.RulesFileKey]
+		cmPrometheus, _, err = updateConfigMapWithRevision(api, cmPrometheus, keptnContext, fmt.Sprintf("rollback to revision %d", revision))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := pruneConfigRevisions(api); err != nil {
+		logger.Error(err.Error())
+	}
+	logger.Info(fmt.Sprintf("Restored revision %d of the Prometheus configuration", revision))
+
+	return deletePrometheusPod()
+}
+
+// rollbackToPreviousConfigRevision restores the newest revision that is older than the given one
+func rollbackToPreviousConfigRevision(revision int, keptnContext string, logger keptn.LoggerInterface) (int, error) {
+	api, err := getKubeClient()
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := listConfigRevisions(api)
+	if err != nil {
+		return 0, err
+	}
+	previous := 0
+	for _, r := range revisions {
+		if r.Revision < revision {
+			previous = r.Revision
+		}
+	}
+	if previous == 0 {
+		return 0, errors.New("no previous revision of the Prometheus configuration available")
+	}
+	return previous, rollbackPrometheusConfig(previous, keptnContext, logger)
+}
+
+// waitForPrometheusReady waits until all Prometheus pods are running and ready
+func waitForPrometheusReady(logger keptn.LoggerInterface) error {
+	promConfig, err := getTargetPrometheusConfig()
+	if err != nil {
+		return err
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		return err
+	}
+	timeout := getReadyTimeout()
+	logger.Debug(fmt.Sprintf("Waiting up to %s for Prometheus to become ready", timeout.String()))
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := api.CoreV1().Pods(promConfig.Namespace).List(metav1.ListOptions{LabelSelector: promConfig.Selector})
+		if err == nil && arePodsReady(pods.Items) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Prometheus did not become ready within %s%s", timeout.String(), getPodStatusReasons(api, promConfig.Namespace, promConfig.Selector))
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+func getRevisionConfigMapName(promConfig *utils.PrometheusConfig, revision int) string {
+	return promConfig.ConfigMapName + revisionConfigMapSuffix + strconv.Itoa(revision)
+}
+
+func arePodsReady(pods []v1.Pod) bool {
+	ready := 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			// pods that are shutting down still run the previous configuration
+			continue
+		}
+		if !isPodReady(pod) {
+			return false
+		}
+		ready++
+	}
+	return ready > 0
+}
+
+func isPodReady(pod v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func getConfigRevisionRetention() int {
+	config, err := utils.GetConfig()
+	if err != nil {
+		return defaultConfigRevisions
+	}
+	return config.ConfigRevisions
+}
+
+func getReadyTimeout() time.Duration {
+	config, err := utils.GetConfig()
+	if err != nil {
+		return defaultReadyTimeout
+	}
+	return config.ReadyTimeout
+}
+
+// HandleConfigRevisions lists the stored revisions of the Prometheus configuration
+func HandleConfigRevisions(rw http.ResponseWriter, req *http.Request) {
+	logger := keptn.NewLogger("", "", "prometheus-service")
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdminRequest(rw, req) {
+		return
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		logger.Error("Could not initialize kubernetes client: " + err.Error())
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	revisions, err := listConfigRevisions(api)
+	if err != nil {
+		logger.Error("Could not list revisions of the Prometheus configuration: " + err.Error())
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, http.StatusOK, revisions)
+}
+
+// HandleConfigRollback restores the revision of the Prometheus configuration given by the query parameter revision
+func HandleConfigRollback(rw http.ResponseWriter, req *http.Request) {
+	keptnContext := uuid.New().String()
+	logger := keptn.NewLogger(keptnContext, "", "prometheus-service")
+	if req.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorizeAdminRequest(rw, req) {
+		return
+	}
+	revision, err := strconv.Atoi(req.URL.Query().Get("revision"))
+	if err != nil || revision < 1 {
+		writeJSON(rw, http.StatusBadRequest, rollbackResponse{Message: "query parameter revision must be a positive number"})
+		return
+	}
+	if err := rollbackPrometheusConfig(revision, keptnContext, logger); err != nil {
+		logger.Error(err.Error())
+		writeJSON(rw, http.StatusInternalServerError, rollbackResponse{Revision: revision, Message: err.Error()})
+		return
+	}
+	writeJSON(rw, http.StatusOK, rollbackResponse{Revision: revision, Message: fmt.Sprintf("Restored revision %d of the Prometheus configuration", revision)})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(body)
+}
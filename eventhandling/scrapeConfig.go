@@ -0,0 +1,313 @@
+package eventhandling
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	prometheusconfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+const scrapeConfigsKey = "scrape_configs"
+
+// scrapeJob is the part of a Prometheus scrape config that is managed by keptn
+type scrapeJob struct {
+	JobName       string          `json:"job_name" yaml:"job_name"`
+	MetricsPath   string          `json:"metrics_path,omitempty" yaml:"metrics_path,omitempty"`
+	StaticConfigs []*staticConfig `json:"static_configs" yaml:"static_configs"`
+}
+
+type staticConfig struct {
+	Targets []string `json:"targets" yaml:"targets"`
+}
+
+// lineReplacement replaces the lines [start, end) of a document with the given lines
+type lineReplacement struct {
+	start int
+	end   int
+	lines []string
+}
+
+// updateScrapeConfigs adds or replaces the given scrape jobs in a prometheus.yml document.
+// Jobs are matched by their job_name. Only the lines of the matched jobs are rewritten, the rest of the document
+// (comments, secrets, fields unknown to the Prometheus library) is left byte-for-byte unchanged.
+func updateScrapeConfigs(document string, jobs []*scrapeJob) (string, error) {
+	if len(jobs) == 0 {
+		return document, nil
+	}
+
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(document), &root); err != nil {
+		return "", fmt.Errorf("could not parse prometheus.yml: %s", err.Error())
+	}
+	lines := strings.Split(document, "\n")
+
+	// an empty document does not contain any nodes
+	mapping := &yamlv3.Node{Kind: yamlv3.MappingNode}
+	if len(root.Content) > 0 {
+		mapping = root.Content[0]
+	}
+	if mapping.Kind != yamlv3.MappingNode {
+		return "", errors.New("could not parse prometheus.yml: document is not a map")
+	}
+
+	keyIndex := -1
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == scrapeConfigsKey {
+			keyIndex = i
+			break
+		}
+	}
+
+	var replacements []lineReplacement
+	if keyIndex < 0 {
+		rendered, err := renderScrapeConfigsSection(nil, jobs)
+		if err != nil {
+			return "", err
+		}
+		end := trimTrailingLines(lines, 0, len(lines))
+		replacements = append(replacements, lineReplacement{start: end, end: end, lines: rendered})
+	} else {
+		sectionStart := mapping.Content[keyIndex].Line - 1
+		sectionEnd := len(lines)
+		if keyIndex+2 < len(mapping.Content) {
+			sectionEnd = mapping.Content[keyIndex+2].Line - 1
+		}
+		sectionEnd = trimTrailingLines(lines, sectionStart+1, sectionEnd)
+
+		sequence := mapping.Content[keyIndex+1]
+		if sequence.Kind == yamlv3.SequenceNode && sequence.Style&yamlv3.FlowStyle == 0 && len(sequence.Content) > 0 {
+			var err error
+			replacements, err = replaceScrapeJobs(lines, sequence, sectionEnd, jobs)
+			if err != nil {
+				return "", err
+			}
+		} else {
+			// scrape_configs is empty or written in flow style, so the whole section is rewritten in block style
+			rendered, err := renderScrapeConfigsSection(sequence, jobs)
+			if err != nil {
+				return "", err
+			}
+			replacements = append(replacements, lineReplacement{start: sectionStart, end: sectionEnd, lines: rendered})
+		}
+	}
+
+	// apply the replacements from the bottom to the top so that line numbers stay valid
+	for i := len(replacements) - 1; i >= 0; i-- {
+		r := replacements[i]
+		updated := append([]string{}, lines[:r.start]...)
+		updated = append(updated, r.lines...)
+		lines = append(updated, lines[r.end:]...)
+	}
+	updated := strings.Join(lines, "\n")
+	if err := validateScrapeConfigs(document, updated, jobs); err != nil {
+		return "", err
+	}
+	return updated, nil
+}
+
+// validateScrapeConfigs checks the result of updateScrapeConfigs before it is written to the config map, so a broken
+// document is reported instead of crashing Prometheus. Documents that the Prometheus library could load before the
+// update have to load afterwards. Others, e.g. with fields unknown to the library, have to remain valid YAML.
+func validateScrapeConfigs(original string, updated string, jobs []*scrapeJob) error {
+	if _, err := prometheusconfig.Load(original); err == nil {
+		config, err := prometheusconfig.Load(updated)
+		if err != nil {
+			return fmt.Errorf("updated prometheus.yml is invalid: %s", err.Error())
+		}
+		for _, job := range jobs {
+			found := false
+			for _, scrapeConfig := range config.ScrapeConfigs {
+				if scrapeConfig.JobName == job.JobName {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("updated prometheus.yml is invalid: scrape job %s is missing", job.JobName)
+			}
+		}
+		return nil
+	}
+
+	var parsed struct {
+		ScrapeConfigs []*scrapeJob `yaml:"scrape_configs"`
+	}
+	if err := yaml.Unmarshal([]byte(updated), &parsed); err != nil {
+		return fmt.Errorf("updated prometheus.yml is invalid: %s", err.Error())
+	}
+	for _, job := range jobs {
+		if findScrapeJob(parsed.ScrapeConfigs, job.JobName) == nil {
+			return fmt.Errorf("updated prometheus.yml is invalid: scrape job %s is missing", job.JobName)
+		}
+	}
+	return nil
+}
+
+// replaceScrapeJobs computes the line replacements for a block style scrape_configs sequence
+func replaceScrapeJobs(lines []string, sequence *yamlv3.Node, sectionEnd int, jobs []*scrapeJob) ([]lineReplacement, error) {
+	var replacements []lineReplacement
+
+	starts := make([]int, len(sequence.Content))
+	for i, item := range sequence.Content {
+		starts[i] = sequenceItemStart(lines, item)
+	}
+
+	firstPrefix := sequenceItemPrefix(lines[starts[0]])
+	lastEnd := sectionEnd
+	written := map[string]bool{}
+	for i, item := range sequence.Content {
+		end := sectionEnd
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		end = trimTrailingLines(lines, starts[i]+1, end)
+		lastEnd = end
+
+		job := findScrapeJob(jobs, scrapeJobName(item))
+		if job == nil {
+			continue
+		}
+		var rendered []string
+		if !written[job.JobName] {
+			// duplicate keptn jobs are removed, the first occurrence is replaced
+			var err error
+			rendered, err = renderScrapeJob(job, sequenceItemPrefix(lines[starts[i]]))
+			if err != nil {
+				return nil, err
+			}
+			written[job.JobName] = true
+		}
+		replacements = append(replacements, lineReplacement{start: starts[i], end: end, lines: rendered})
+	}
+
+	var appended []string
+	for _, job := range jobs {
+		if written[job.JobName] {
+			continue
+		}
+		rendered, err := renderScrapeJob(job, firstPrefix)
+		if err != nil {
+			return nil, err
+		}
+		appended = append(appended, rendered...)
+		written[job.JobName] = true
+	}
+	if len(appended) > 0 {
+		replacements = append(replacements, lineReplacement{start: lastEnd, end: lastEnd, lines: appended})
+	}
+	return replacements, nil
+}
+
+// renderScrapeConfigsSection renders a complete scrape_configs section containing the items of an existing
+// (flow style or empty) sequence followed by the given jobs
+func renderScrapeConfigsSection(sequence *yamlv3.Node, jobs []*scrapeJob) ([]string, error) {
+	section := []string{scrapeConfigsKey + ":"}
+	written := map[string]bool{}
+	if sequence != nil && sequence.Kind == yamlv3.SequenceNode {
+		for _, item := range sequence.Content {
+			if job := findScrapeJob(jobs, scrapeJobName(item)); job != nil {
+				if written[job.JobName] {
+					continue
+				}
+				rendered, err := renderScrapeJob(job, "")
+				if err != nil {
+					return nil, err
+				}
+				section = append(section, rendered...)
+				written[job.JobName] = true
+				continue
+			}
+			out, err := yamlv3.Marshal(item)
+			if err != nil {
+				return nil, err
+			}
+			section = append(section, indentAsSequenceItem(string(out), "")...)
+		}
+	}
+	for _, job := range jobs {
+		if written[job.JobName] {
+			continue
+		}
+		rendered, err := renderScrapeJob(job, "")
+		if err != nil {
+			return nil, err
+		}
+		section = append(section, rendered...)
+		written[job.JobName] = true
+	}
+	return section, nil
+}
+
+// renderScrapeJob renders a scrape job as a sequence item whose dash is preceded by the given prefix
+func renderScrapeJob(job *scrapeJob, prefix string) ([]string, error) {
+	out, err := yaml.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	return indentAsSequenceItem(string(out), prefix), nil
+}
+
+func indentAsSequenceItem(rendered string, prefix string) []string {
+	var result []string
+	for i, line := range strings.Split(strings.TrimRight(rendered, "\n"), "\n") {
+		if i == 0 {
+			result = append(result, prefix+"- "+line)
+		} else {
+			result = append(result, prefix+"  "+line)
+		}
+	}
+	return result
+}
+
+// sequenceItemStart returns the index of the line containing the dash of a sequence item
+func sequenceItemStart(lines []string, item *yamlv3.Node) int {
+	start := item.Line - 1
+	if !strings.HasPrefix(strings.TrimSpace(lines[start]), "-") && start > 0 && strings.TrimSpace(lines[start-1]) == "-" {
+		return start - 1
+	}
+	return start
+}
+
+// sequenceItemPrefix returns the whitespace in front of the dash of a sequence item
+func sequenceItemPrefix(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// trimTrailingLines moves end back over empty lines and comments, but not before min
+func trimTrailingLines(lines []string, min int, end int) int {
+	for end > min {
+		trimmed := strings.TrimSpace(lines[end-1])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+func scrapeJobName(item *yamlv3.Node) string {
+	if item.Kind != yamlv3.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(item.Content); i += 2 {
+		if item.Content[i].Value == "job_name" {
+			return item.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+func findScrapeJob(jobs []*scrapeJob, name string) *scrapeJob {
+	if name == "" {
+		return nil
+	}
+	for _, job := range jobs {
+		if job != nil && job.JobName == name {
+			return job
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package eventhandling
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpdateScrapeConfigs(t *testing.T) {
+	carts := createScrapeJobConfig("sockshop", "dev", "carts", false, false)
+
+	tests := []struct {
+		name     string
+		document string
+		jobs     []*scrapeJob
+		// contains are expected in the result, in this order
+		contains []string
+		absent   []string
+	}{
+		{
+			name: "comments are preserved",
+			document: `# global settings
+global:
+  scrape_interval: 15s # default
+scrape_configs:
+  # the Prometheus server itself
+  - job_name: prometheus
+    static_configs:
+      - targets: ['localhost:9090']
+  # managed by keptn
+  - job_name: carts-sockshop-dev
+    metrics_path: /old
+    static_configs:
+      - targets: ['old:80']
+# trailing comment
+`,
+			jobs: []*scrapeJob{carts},
+			contains: []string{
+				"# global settings",
+				"  scrape_interval: 15s # default",
+				"  # the Prometheus server itself",
+				"  # managed by keptn",
+				"  - job_name: carts-sockshop-dev",
+				"    metrics_path: /prometheus",
+				"# trailing comment",
+			},
+			absent: []string{"/old", "old:80"},
+		},
+		{
+			name: "anchors and aliases are preserved",
+			document: `scrape_configs:
+- job_name: node
+  static_configs: &targets
+  - targets: ['node:9100']
+- job_name: node-copy
+  static_configs: *targets
+`,
+			jobs: []*scrapeJob{carts},
+			contains: []string{
+				"  static_configs: &targets",
+				"  static_configs: *targets",
+				"- job_name: carts-sockshop-dev",
+			},
+		},
+		{
+			name: "indentation of the sequence is kept",
+			document: `scrape_configs:
+    - job_name: prometheus
+      static_configs:
+        - targets: ['localhost:9090']
+`,
+			jobs: []*scrapeJob{carts},
+			contains: []string{
+				"    - job_name: prometheus",
+				"    - job_name: carts-sockshop-dev",
+				"      metrics_path: /prometheus",
+			},
+		},
+		{
+			name: "flow style section is rewritten",
+			document: `global:
+  scrape_interval: 15s
+scrape_configs: [{job_name: prometheus, static_configs: [{targets: ['localhost:9090']}]}]
+`,
+			jobs: []*scrapeJob{carts},
+			contains: []string{
+				"scrape_configs:",
+				"job_name: prometheus",
+				"- job_name: carts-sockshop-dev",
+			},
+		},
+		{
+			name:     "missing section is added",
+			document: "global:\n  scrape_interval: 15s\n",
+			jobs:     []*scrapeJob{carts},
+			contains: []string{"global:", "scrape_configs:", "- job_name: carts-sockshop-dev"},
+		},
+		{
+			name: "duplicate keptn jobs are removed",
+			document: `scrape_configs:
+- job_name: carts-sockshop-dev
+  static_configs:
+  - targets: ['a:80']
+- job_name: carts-sockshop-dev
+  static_configs:
+  - targets: ['b:80']
+`,
+			jobs:     []*scrapeJob{carts},
+			contains: []string{"- job_name: carts-sockshop-dev"},
+			absent:   []string{"a:80", "b:80"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated, err := updateScrapeConfigs(tt.document, tt.jobs)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			rest := updated
+			for _, expected := range tt.contains {
+				index := strings.Index(rest, expected)
+				if index < 0 {
+					t.Fatalf("expected %q in order in:\n%s", expected, updated)
+				}
+				rest = rest[index+len(expected):]
+			}
+			for _, unexpected := range tt.absent {
+				if strings.Contains(updated, unexpected) {
+					t.Errorf("did not expect %q in:\n%s", unexpected, updated)
+				}
+			}
+			if strings.Count(updated, "job_name: carts-sockshop-dev") != 1 {
+				t.Errorf("expected the job exactly once in:\n%s", updated)
+			}
+		})
+	}
+}
+
+func TestValidateScrapeConfigs(t *testing.T) {
+	carts := createScrapeJobConfig("sockshop", "dev", "carts", false, false)
+	valid := "scrape_configs:\n- job_name: carts-sockshop-dev\n  static_configs:\n  - targets: ['carts:80']\n"
+
+	tests := []struct {
+		name     string
+		original string
+		updated  string
+		wantErr  bool
+	}{
+		{name: "valid document", original: "", updated: valid},
+		{name: "broken indentation", original: "", updated: "scrape_configs:\n- job_name: carts-sockshop-dev\n static_configs:\n  - targets: ['carts:80']\n", wantErr: true},
+		{name: "job missing", original: "", updated: "scrape_configs: []\n", wantErr: true},
+		{name: "invalid field", original: "", updated: valid + "scrape_interval_typo: 1m\n", wantErr: true},
+		{name: "field unknown to the library is kept", original: "unknown_field: true\n", updated: valid + "unknown_field: true\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateScrapeConfigs(tt.original, tt.updated, []*scrapeJob{carts})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package eventhandling
+
+import (
+	"fmt"
+
+	"github.com/keptn/go-utils/pkg/api/models"
+	configutils "github.com/keptn/go-utils/pkg/api/utils"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// sliResourceURI is the resource in the configuration-service holding custom SLI queries, in the format of the SLI
+// files of other SLI providers
+const sliResourceURI = "prometheus/sli.yaml"
+
+// customQuery is a custom SLI query together with the place it has been read from
+type customQuery struct {
+	query  string
+	source string
+}
+
+// sliConfiguration holds the custom SLI queries and the metric profile of the default queries of a service in a stage
+type sliConfiguration struct {
+	queries map[string]customQuery
+	profile string
+	// profileSource is the place the profile has been read from
+	profileSource string
+}
+
+// sliProfileSetting is the selection of a metric profile in prometheus/sli.yaml or a prometheus-sli-config config map
+type sliProfileSetting struct {
+	Profile string `yaml:"profile"`
+}
+
+// sliResourceHandler retrieves the prometheus/sli.yaml resources of a project, a stage and a service
+type sliResourceHandler interface {
+	GetProjectResource(project string, resourceURI string) (*models.Resource, error)
+	GetStageResource(project string, stage string, resourceURI string) (*models.Resource, error)
+	GetServiceResource(project string, stage string, service string, resourceURI string) (*models.Resource, error)
+}
+
+// getSLIConfiguration returns the custom SLI queries and the metric profile of a service in a stage. Each SLI and the
+// profile are resolved separately from the prometheus/sli.yaml resource of the service, the stage and the project,
+// then from the prometheus-sli-config-<project> and prometheus-sli-config config maps. The profile defaults to
+// METRIC_PROFILE. An error is returned if a source cannot be read, as the default queries would replace the custom
+// ones otherwise.
+func getSLIConfiguration(config *utils.ServiceConfig, project string, stage string, service string, logger keptn.LoggerInterface) (*sliConfiguration, error) {
+	sliConfig := &sliConfiguration{
+		queries:       map[string]customQuery{},
+		profile:       config.MetricProfile,
+		profileSource: "METRIC_PROFILE",
+	}
+	// the sources are read with increasing precedence, so more specific settings replace the general ones
+	if err := addConfigMapQueries(sliConfig, config, keptnPrometheusSLIConfigMapName, logger); err != nil {
+		return nil, err
+	}
+	if err := addConfigMapQueries(sliConfig, config, keptnPrometheusSLIConfigMapName+"-"+project, logger); err != nil {
+		return nil, err
+	}
+	resourceHandler := configutils.NewResourceHandler(getConfigurationServiceURL())
+	if err := addResourceLayerQueries(sliConfig, resourceHandler, project, stage, service, logger); err != nil {
+		return nil, err
+	}
+	return sliConfig, nil
+}
+
+// addResourceLayerQueries adds the queries of the prometheus/sli.yaml resources of the project, the stage and the
+// service. Only a missing resource is skipped.
+func addResourceLayerQueries(sliConfig *sliConfiguration, resourceHandler sliResourceHandler, project string, stage string, service string, logger keptn.LoggerInterface) error {
+	layers := []struct {
+		source string
+		get    func() (*models.Resource, error)
+	}{
+		{
+			source: fmt.Sprintf("%s of project %s", sliResourceURI, project),
+			get: func() (*models.Resource, error) {
+				return resourceHandler.GetProjectResource(project, sliResourceURI)
+			},
+		},
+		{
+			source: fmt.Sprintf("%s of stage %s", sliResourceURI, stage),
+			get: func() (*models.Resource, error) {
+				return resourceHandler.GetStageResource(project, stage, sliResourceURI)
+			},
+		},
+		{
+			source: fmt.Sprintf("%s of service %s in stage %s", sliResourceURI, service, stage),
+			get: func() (*models.Resource, error) {
+				return resourceHandler.GetServiceResource(project, stage, service, sliResourceURI)
+			},
+		},
+	}
+	for _, layer := range layers {
+		resource, err := layer.get()
+		if err == configutils.ResourceNotFoundError {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("could not retrieve %s: %s", layer.source, err.Error())
+		}
+		if resource != nil {
+			addResourceQueries(sliConfig, resource.ResourceContent, layer.source, logger)
+		}
+	}
+	return nil
+}
+
+func addConfigMapQueries(sliConfig *sliConfiguration, config *utils.ServiceConfig, name string, logger keptn.LoggerInterface) error {
+	kubeClient, err := getKubeClient()
+	if err != nil {
+		return fmt.Errorf("could not read custom SLI queries from config map %s: %s", name, err.Error())
+	}
+	configMap, err := kubeClient.CoreV1().ConfigMaps(config.KeptnNamespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("could not read custom SLI queries from config map %s: %s", name, err.Error())
+	}
+	if configMap.Data["profile"] != "" {
+		sliConfig.profile = configMap.Data["profile"]
+		sliConfig.profileSource = "config map " + name
+	}
+	if configMap.Data["custom-queries"] == "" {
+		return nil
+	}
+	customQueries := map[string]string{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["custom-queries"]), &customQueries); err != nil {
+		logger.Error(fmt.Sprintf("Invalid custom SLI queries in config map %s: %s", name, err.Error()))
+		return nil
+	}
+	for sli, query := range customQueries {
+		if query != "" {
+			sliConfig.queries[sli] = customQuery{query: query, source: "config map " + name}
+		}
+	}
+	return nil
+}
+
+func addResourceQueries(sliConfig *sliConfiguration, content string, source string, logger keptn.LoggerInterface) {
+	resource := keptn.SLIConfig{}
+	setting := sliProfileSetting{}
+	if err := yaml.Unmarshal([]byte(content), &resource); err != nil {
+		logger.Error(fmt.Sprintf("Invalid custom SLI queries in %s: %s", source, err.Error()))
+		return
+	}
+	if err := yaml.Unmarshal([]byte(content), &setting); err == nil && setting.Profile != "" {
+		sliConfig.profile = setting.Profile
+		sliConfig.profileSource = source
+	}
+	for sli, query := range resource.Indicators {
+		if query != "" {
+			sliConfig.queries[sli] = customQuery{query: query, source: source}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package eventhandling
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/keptn/go-utils/pkg/api/models"
+	configutils "github.com/keptn/go-utils/pkg/api/utils"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+)
+
+// fakeSLIResources holds the content of prometheus/sli.yaml by level. A missing level is not found, an error is
+// returned for the levels in failing.
+type fakeSLIResources struct {
+	resources map[string]string
+	failing   map[string]bool
+}
+
+func (f *fakeSLIResources) get(level string) (*models.Resource, error) {
+	if f.failing[level] {
+		return nil, errors.New("503 Service Unavailable")
+	}
+	content, ok := f.resources[level]
+	if !ok {
+		return nil, configutils.ResourceNotFoundError
+	}
+	return &models.Resource{ResourceContent: content}, nil
+}
+
+func (f *fakeSLIResources) GetProjectResource(project string, resourceURI string) (*models.Resource, error) {
+	return f.get("project")
+}
+
+func (f *fakeSLIResources) GetStageResource(project string, stage string, resourceURI string) (*models.Resource, error) {
+	return f.get("stage")
+}
+
+func (f *fakeSLIResources) GetServiceResource(project string, stage string, service string, resourceURI string) (*models.Resource, error) {
+	return f.get("service")
+}
+
+func TestAddResourceLayerQueries(t *testing.T) {
+	project := "spec_version: '1.0'\nprofile: istio\nindicators:\n  throughput: project-throughput\n  error_rate: project-error-rate\n  response_time_p95: project-p95\n"
+	stage := "spec_version: '1.0'\nindicators:\n  error_rate: stage-error-rate\n  response_time_p95: stage-p95\n"
+	service := "spec_version: '1.0'\nprofile: linkerd\nindicators:\n  response_time_p95: service-p95\n"
+
+	tests := []struct {
+		name        string
+		resources   map[string]string
+		failing     map[string]bool
+		wantQueries map[string]string
+		wantProfile string
+		wantErr     bool
+	}{
+		{
+			name:      "service before stage before project",
+			resources: map[string]string{"project": project, "stage": stage, "service": service},
+			wantQueries: map[string]string{
+				"throughput":        "project-throughput",
+				"error_rate":        "stage-error-rate",
+				"response_time_p95": "service-p95",
+			},
+			wantProfile: "linkerd",
+		},
+		{
+			name:      "missing levels are skipped",
+			resources: map[string]string{"project": project},
+			wantQueries: map[string]string{
+				"throughput":        "project-throughput",
+				"error_rate":        "project-error-rate",
+				"response_time_p95": "project-p95",
+			},
+			wantProfile: "istio",
+		},
+		{
+			name:        "no resources",
+			wantQueries: map[string]string{"throughput": "config-map-throughput"},
+			wantProfile: "default",
+		},
+		{
+			name:      "invalid resources are skipped",
+			resources: map[string]string{"stage": "indicators: [", "service": service},
+			wantQueries: map[string]string{
+				"throughput":        "config-map-throughput",
+				"response_time_p95": "service-p95",
+			},
+			wantProfile: "linkerd",
+		},
+		{
+			name:      "unavailable configuration-service",
+			resources: map[string]string{"project": project, "service": service},
+			failing:   map[string]bool{"stage": true},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the queries of the config maps are read before the resources
+			sliConfig := &sliConfiguration{
+				queries:       map[string]customQuery{"throughput": {query: "config-map-throughput", source: "config map"}},
+				profile:       "default",
+				profileSource: "METRIC_PROFILE",
+			}
+			resources := &fakeSLIResources{resources: tt.resources, failing: tt.failing}
+			err := addResourceLayerQueries(sliConfig, resources, "sockshop", "dev", "carts", keptn.NewLogger("", "", "prometheus-service"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			queries := map[string]string{}
+			for sli, query := range sliConfig.queries {
+				queries[sli] = query.query
+			}
+			if !reflect.DeepEqual(queries, tt.wantQueries) {
+				t.Errorf("expected queries %v, got %v", tt.wantQueries, queries)
+			}
+			if sliConfig.profile != tt.wantProfile {
+				t.Errorf("expected profile %s, got %s", tt.wantProfile, sliConfig.profile)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package eventhandling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	keptn "github.com/keptn/go-utils/pkg/lib"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// defaultVerifyTimeout is used if the service configuration cannot be read
+const defaultVerifyTimeout = 30 * time.Second
+
+const (
+	healthOK      = "ok"
+	healthUp      = "up"
+	healthUnknown = "unknown"
+	// healthMissing is reported for rules and scrape jobs that are not known to Prometheus
+	healthMissing = "missing"
+)
+
+// ruleHealth is the state of a generated rule as reported by the Prometheus rules API
+type ruleHealth struct {
+	Group     string `json:"group"`
+	Name      string `json:"name"`
+	Health    string `json:"health"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// targetHealth is the state of a scrape target of a generated scrape job as reported by the Prometheus targets API
+type targetHealth struct {
+	Job       string `json:"job"`
+	ScrapeURL string `json:"scrapeUrl,omitempty"`
+	Health    string `json:"health"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// verificationReport states whether Prometheus has loaded the generated rules and scrapes the generated jobs
+type verificationReport struct {
+	Rules   []*ruleHealth   `json:"rules"`
+	Targets []*targetHealth `json:"targets"`
+}
+
+type prometheusRulesResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Groups []struct {
+			Name  string `json:"name"`
+			Rules []struct {
+				Name      string `json:"name"`
+				Health    string `json:"health"`
+				LastError string `json:"lastError"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"data"`
+}
+
+type prometheusTargetsResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ActiveTargets []struct {
+			Labels    map[string]string `json:"labels"`
+			ScrapeURL string            `json:"scrapeUrl"`
+			Health    string            `json:"health"`
+			LastError string            `json:"lastError"`
+		} `json:"activeTargets"`
+	} `json:"data"`
+}
+
+// verifyMonitoringConfig queries the rules and targets APIs of Prometheus until all generated rules have been
+// evaluated and every generated scrape job has a target that is up, or the timeout is reached
+func verifyMonitoringConfig(installation *prometheusInstallation, desired *monitoringConfig, logger keptn.LoggerInterface) (*verificationReport, error) {
+	if installation.URL == "" {
+		logger.Debug("Address of the Prometheus API is unknown, skipping the verification of rules and targets")
+		return nil, nil
+	}
+	timeout := getVerifyTimeout()
+	deadline := time.Now().Add(timeout)
+	logger.Debug(fmt.Sprintf("Verifying rules and scrape targets at %s for up to %s", installation.URL, timeout.String()))
+
+	client := &http.Client{Timeout: healthPollInterval}
+	for {
+		report, err := getVerificationReport(client, installation.URL, desired)
+		if err == nil && report.isSettled() {
+			return report, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return nil, fmt.Errorf("could not verify the Prometheus configuration: %s", err.Error())
+			}
+			return report, nil
+		}
+		time.Sleep(healthPollInterval)
+	}
+}
+
+func getVerificationReport(client *http.Client, prometheusURL string, desired *monitoringConfig) (*verificationReport, error) {
+	rules := &prometheusRulesResponse{}
+	if err := getPrometheusAPI(client, prometheusURL+"/api/v1/rules", rules); err != nil {
+		return nil, err
+	}
+	if rules.Status != "success" {
+		return nil, fmt.Errorf("rules API returned %s: %s", rules.Status, rules.Error)
+	}
+	targets := &prometheusTargetsResponse{}
+	if err := getPrometheusAPI(client, prometheusURL+"/api/v1/targets", targets); err != nil {
+		return nil, err
+	}
+	if targets.Status != "success" {
+		return nil, fmt.Errorf("targets API returned %s: %s", targets.Status, targets.Error)
+	}
+
+	report := &verificationReport{}
+	for _, stage := range desired.stages {
+		if stage.alertingGroup != nil {
+			for _, rule := range stage.alertingGroup.rules {
+				name := rule.Alert
+				if name == "" {
+					name = rule.Record
+				}
+				health := &ruleHealth{Group: stage.alertingGroup.name, Name: name, Health: healthMissing}
+				for _, group := range rules.Data.Groups {
+					if group.Name != health.Group {
+						continue
+					}
+					for _, loaded := range group.Rules {
+						if loaded.Name == name {
+							health.Health = loaded.Health
+							health.LastError = loaded.LastError
+						}
+					}
+				}
+				report.Rules = append(report.Rules, health)
+			}
+		}
+
+		for _, job := range stage.scrapeJobs {
+			found := false
+			for _, target := range targets.Data.ActiveTargets {
+				if target.Labels["job"] != job.JobName {
+					continue
+				}
+				found = true
+				report.Targets = append(report.Targets, &targetHealth{
+					Job:       job.JobName,
+					ScrapeURL: target.ScrapeURL,
+					Health:    target.Health,
+					LastError: target.LastError,
+				})
+			}
+			if !found {
+				report.Targets = append(report.Targets, &targetHealth{Job: job.JobName, Health: healthMissing})
+			}
+		}
+	}
+	sort.SliceStable(report.Targets, func(i, j int) bool {
+		return report.Targets[i].Job < report.Targets[j].Job
+	})
+	return report, nil
+}
+
+func getPrometheusAPI(client *http.Client, url string, result interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// isSettled returns true if all rules have been evaluated and all jobs have a target that is up
+func (r *verificationReport) isSettled() bool {
+	for _, rule := range r.Rules {
+		if rule.Health == healthUnknown || rule.Health == healthMissing {
+			return false
+		}
+	}
+	return len(r.getJobsWithoutTargetUp()) == 0
+}
+
+// getFailedRules returns the generated rules that are not loaded or failed to evaluate
+func (r *verificationReport) getFailedRules() []string {
+	if r == nil {
+		return nil
+	}
+	var failed []string
+	for _, rule := range r.Rules {
+		if rule.Health == healthMissing {
+			failed = append(failed, fmt.Sprintf("rule %s is not loaded", rule.Name))
+		} else if rule.Health != healthOK && rule.Health != healthUnknown {
+			failed = append(failed, fmt.Sprintf("rule %s is %s: %s", rule.Name, rule.Health, rule.LastError))
+		}
+	}
+	return failed
+}
+
+func (r *verificationReport) getJobsWithoutTargetUp() []string {
+	up := map[string]bool{}
+	var jobs []string
+	for _, target := range r.Targets {
+		if _, ok := up[target.Job]; !ok {
+			jobs = append(jobs, target.Job)
+		}
+		up[target.Job] = up[target.Job] || target.Health == healthUp
+	}
+	var down []string
+	for _, job := range jobs {
+		if !up[job] {
+			down = append(down, job)
+		}
+	}
+	return down
+}
+
+// summary describes scrape jobs without a target that is up, e.g. because the service does not expose metrics yet
+func (r *verificationReport) summary() string {
+	if r == nil {
+		return ""
+	}
+	down := r.getJobsWithoutTargetUp()
+	if len(down) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("no scrape target up for job(s) %s", strings.Join(down, ", "))
+}
+
+func getVerifyTimeout() time.Duration {
+	config, err := utils.GetConfig()
+	if err != nil {
+		return defaultVerifyTimeout
+	}
+	return config.VerifyTimeout
+}
@@ -0,0 +1,223 @@
+package eventhandling
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	configutils "github.com/keptn/go-utils/pkg/api/utils"
+	keptn "github.com/keptn/go-utils/pkg/lib"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// regenerateDelay collects the changes that arrive shortly after each other, e.g. of several slo.yaml files in one
+// commit, so Prometheus is restarted once
+const regenerateDelay = 10 * time.Second
+
+// regenerateRequest asks for the alerting rules of a project to be regenerated. An empty project stands for all
+// projects, e.g. after a change of the global prometheus-sli-config config map.
+type regenerateRequest struct {
+	project string
+	reason  string
+}
+
+var regenerateRequests = make(chan regenerateRequest, 100)
+
+// WatchMonitoringInputs regenerates the alerting rules of the monitored services when the custom SLI queries in the
+// prometheus-sli-config config maps or the slo.yaml files in the configuration-service change
+func WatchMonitoringInputs(logger keptn.LoggerInterface) {
+	go watchSLIConfigMaps(logger)
+	go pollSLOs(logger)
+	for {
+		request := <-regenerateRequests
+		requests := []regenerateRequest{request}
+		timeout := time.After(regenerateDelay)
+	collect:
+		for {
+			select {
+			case request := <-regenerateRequests:
+				requests = append(requests, request)
+			case <-timeout:
+				break collect
+			}
+		}
+		regenerateAlertingRules(requests, logger)
+	}
+}
+
+func requestRegeneration(project string, reason string) {
+	select {
+	case regenerateRequests <- regenerateRequest{project: project, reason: reason}:
+	default:
+		// the queue is full, the rules are repaired by the next reconciliation
+	}
+}
+
+// regenerateAlertingRules applies the configuration of the projects of the requests and stores the regenerated rules
+// of the services whose rules changed in the configuration-service
+func regenerateAlertingRules(requests []regenerateRequest, logger keptn.LoggerInterface) {
+	var projects []string
+	var reasons []string
+	allProjects := false
+	for _, request := range requests {
+		if !containsString(reasons, request.reason) {
+			reasons = append(reasons, request.reason)
+		}
+		if request.project == "" {
+			allProjects = true
+		} else if !containsString(projects, request.project) {
+			projects = append(projects, request.project)
+		}
+	}
+	if allProjects {
+		projects = nil
+	}
+	reason := strings.Join(reasons, ", ")
+	logger.Info("Regenerating alerting rules after " + reason)
+
+	result, err := syncMonitoringConfig(projects, "regenerate alerting rules after "+reason, logger)
+	if err != nil {
+		logger.Error("Could not regenerate alerting rules: " + err.Error())
+		return
+	}
+	if !result.applied {
+		logger.Info("Alerting rules are up to date")
+		return
+	}
+
+	changed := &monitoringConfig{}
+	for _, stage := range result.desired.stages {
+		if stage.alertingGroup == nil {
+			continue
+		}
+		for _, change := range result.changes {
+			if change.kind == driftKindRuleGroup && change.name == stage.alertingGroup.name {
+				changed.stages = append(changed.stages, stage)
+				break
+			}
+		}
+	}
+	if _, err := storeMonitoringResources(changed, logger); err != nil {
+		logger.Error("Could not store the regenerated alerting rules: " + err.Error())
+		return
+	}
+	logger.Info("Regenerated alerting rules of service(s) " + strings.Join(changed.services(), ", "))
+}
+
+// watchSLIConfigMaps requests a regeneration when a prometheus-sli-config config map is created, changed or deleted
+func watchSLIConfigMaps(logger keptn.LoggerInterface) {
+	config, err := utils.GetConfig()
+	if err != nil {
+		logger.Error("Could not watch the custom SLI queries: " + err.Error())
+		return
+	}
+	api, err := getKubeClient()
+	if err != nil {
+		logger.Error("Could not watch the custom SLI queries: " + err.Error())
+		return
+	}
+
+	// the config maps that exist at startup are reported as added before the cache is synced
+	var synced int32
+	onChange := func(obj interface{}) {
+		if atomic.LoadInt32(&synced) == 0 {
+			return
+		}
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		configMap, ok := obj.(*v1.ConfigMap)
+		if !ok || (configMap.Name != keptnPrometheusSLIConfigMapName && !strings.HasPrefix(configMap.Name, keptnPrometheusSLIConfigMapName+"-")) {
+			return
+		}
+		project := strings.TrimPrefix(configMap.Name, keptnPrometheusSLIConfigMapName+"-")
+		if configMap.Name == keptnPrometheusSLIConfigMapName {
+			project = ""
+		}
+		requestRegeneration(project, "change of config map "+configMap.Name)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(api, 0, informers.WithNamespace(config.KeptnNamespace))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onChange,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			oldConfigMap, oldOK := oldObj.(*v1.ConfigMap)
+			newConfigMap, newOK := newObj.(*v1.ConfigMap)
+			// periodic resyncs and changes of the metadata do not affect the queries
+			if oldOK && newOK && oldConfigMap.Data["custom-queries"] == newConfigMap.Data["custom-queries"] &&
+				oldConfigMap.Data["profile"] == newConfigMap.Data["profile"] {
+				return
+			}
+			onChange(newObj)
+		},
+		DeleteFunc: onChange,
+	})
+
+	stop := make(chan struct{})
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+		logger.Error("Could not sync the prometheus-sli-config config maps")
+		return
+	}
+	atomic.StoreInt32(&synced, 1)
+	logger.Debug("Watching the prometheus-sli-config config maps in namespace " + config.KeptnNamespace)
+	<-stop
+}
+
+// pollSLOs requests a regeneration when the slo.yaml or prometheus/sli.yaml of a monitored service changes in the
+// configuration-service
+func pollSLOs(logger keptn.LoggerInterface) {
+	// the checksums of the first poll are recorded without requesting a regeneration
+	var checksums map[string]string
+	for {
+		interval := minReloadCheckInterval
+		config, err := utils.GetConfig()
+		if err == nil && config.SLOPollInterval > 0 {
+			interval = config.SLOPollInterval
+		}
+		if err != nil || config.SLOPollInterval == 0 {
+			time.Sleep(interval)
+			continue
+		}
+
+		monitored, err := getMonitoredServices(nil)
+		if err != nil {
+			logger.Debug("Could not check the SLOs for changes: " + err.Error())
+			time.Sleep(interval)
+			continue
+		}
+		resourceHandler := configutils.NewResourceHandler(getConfigurationServiceURL())
+		current := map[string]string{}
+		for _, m := range monitored {
+			for _, resourceURI := range []string{"slo.yaml", sliResourceURI} {
+				key := m.project + "/" + m.stage + "/" + m.service + "/" + resourceURI
+				resource, err := resourceHandler.GetServiceResource(m.project, m.stage, m.service, resourceURI)
+				if err == configutils.ResourceNotFoundError || (err == nil && resource == nil) {
+					// a deleted resource is recorded with an empty checksum, so its deletion is detected
+					current[key] = ""
+				} else if err != nil {
+					// the previous checksum is kept while the configuration-service is unavailable
+					if previous, ok := checksums[key]; ok {
+						current[key] = previous
+					}
+					continue
+				} else {
+					sum := sha256.Sum256([]byte(resource.ResourceContent))
+					current[key] = hex.EncodeToString(sum[:])
+				}
+				if checksums != nil && checksums[key] != current[key] {
+					requestRegeneration(m.project, "change of "+resourceURI+" of service "+m.service+" in stage "+m.stage)
+				}
+			}
+		}
+		checksums = current
+		time.Sleep(interval)
+	}
+}
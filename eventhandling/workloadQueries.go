@@ -0,0 +1,93 @@
+package eventhandling
+
+import (
+	"regexp"
+
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+// The workload SLIs are built from the container metrics of cAdvisor and the metrics of kube-state-metrics. They
+// select the deployment of the service in the namespace of the stage, so the filters of the SLO file, which refer
+// to the labels of the requests, are not applied.
+
+// getDeploymentName returns the deployment serving the service in a stage, which is the primary deployment for
+// blue/green deployments
+func getDeploymentName(service string, deploymentType string) string {
+	if deploymentType == "blue_green_service" {
+		return service + "-primary"
+	}
+	return service
+}
+
+// podMatchers select the pods of the deployment of the service in the stage. The pods of a deployment are named
+// <deployment>-<replica set hash>-<pod hash>, so the pods of e.g. carts-db are not selected for carts.
+func podMatchers(project string, stage string, service string, deploymentType string) []*promlabels.Matcher {
+	deployment := getDeploymentName(service, deploymentType)
+	return []*promlabels.Matcher{
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "namespace", project+"-"+stage),
+		promlabels.MustNewMatcher(promlabels.MatchRegexp, "pod", regexp.QuoteMeta(deployment)+"-[a-z0-9]+-[a-z0-9]+"),
+	}
+}
+
+// containerMatchers select the containers of the pods in cAdvisor metrics, without the pause containers and the
+// aggregates of the pods
+func containerMatchers(project string, stage string, service string, deploymentType string) []*promlabels.Matcher {
+	return append(podMatchers(project, stage, service, deploymentType),
+		promlabels.MustNewMatcher(promlabels.MatchNotEqual, "container", ""),
+		promlabels.MustNewMatcher(promlabels.MatchNotEqual, "container", "POD"),
+	)
+}
+
+func getCPUSaturationQuery(config *utils.ServiceConfig, project string, stage string, service string, deploymentType string) (string, error) {
+	usage, err := getSelector("container_cpu_usage_seconds_total", containerMatchers(project, stage, service, deploymentType), nil)
+	if err != nil {
+		return "", err
+	}
+	limits, err := getSelector("kube_pod_container_resource_limits", podMatchers(project, stage, service, deploymentType), nil,
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "resource", "cpu"))
+	if err != nil {
+		return "", err
+	}
+	// e.g. sum(rate(container_cpu_usage_seconds_total{container!="",container!="POD",namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+"}[30m]))/sum(kube_pod_container_resource_limits{namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+",resource="cpu"})
+	return "sum(rate(" + usage + "[" + config.QueryRange + "]))/sum(" + limits + ")", nil
+}
+
+func getMemorySaturationQuery(project string, stage string, service string, deploymentType string) (string, error) {
+	usage, err := getSelector("container_memory_working_set_bytes", containerMatchers(project, stage, service, deploymentType), nil)
+	if err != nil {
+		return "", err
+	}
+	limits, err := getSelector("kube_pod_container_resource_limits", podMatchers(project, stage, service, deploymentType), nil,
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "resource", "memory"))
+	if err != nil {
+		return "", err
+	}
+	return "sum(" + usage + ")/sum(" + limits + ")", nil
+}
+
+func getPodRestartsQuery(config *utils.ServiceConfig, project string, stage string, service string, deploymentType string) (string, error) {
+	restarts, err := getSelector("kube_pod_container_status_restarts_total", podMatchers(project, stage, service, deploymentType), nil)
+	if err != nil {
+		return "", err
+	}
+	return "sum(increase(" + restarts + "[" + config.QueryRange + "]))", nil
+}
+
+func getReadyReplicaRatioQuery(project string, stage string, service string, deploymentType string) (string, error) {
+	deploymentMatchers := []*promlabels.Matcher{
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "namespace", project+"-"+stage),
+		promlabels.MustNewMatcher(promlabels.MatchEqual, "deployment", getDeploymentName(service, deploymentType)),
+	}
+	available, err := getSelector("kube_deployment_status_replicas_available", deploymentMatchers, nil)
+	if err != nil {
+		return "", err
+	}
+	desired, err := getSelector("kube_deployment_spec_replicas", deploymentMatchers, nil)
+	if err != nil {
+		return "", err
+	}
+	// the available replicas have been ready for the minReadySeconds of the deployment
+	return "sum(" + available + ")/sum(" + desired + ")", nil
+}
@@ -0,0 +1,171 @@
+package eventhandling
+
+import (
+	"testing"
+	"time"
+
+	promlabels "github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/keptn-contrib/prometheus-service/utils"
+)
+
+func TestGetDeploymentName(t *testing.T) {
+	tests := []struct {
+		deploymentType string
+		want           string
+	}{
+		{deploymentType: "", want: "carts"},
+		{deploymentType: "direct", want: "carts"},
+		{deploymentType: "blue_green_service", want: "carts-primary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.deploymentType, func(t *testing.T) {
+			if got := getDeploymentName("carts", tt.deploymentType); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPodMatchers(t *testing.T) {
+	tests := []struct {
+		name           string
+		deploymentType string
+		matches        []string
+		noMatches      []string
+	}{
+		{
+			name:           "direct",
+			deploymentType: "direct",
+			matches:        []string{"carts-5b8f7c9d4-x2x7k"},
+			noMatches:      []string{"carts-db-5b8f7c9d4-x2x7k", "carts-primary-5b8f7c9d4-x2x7k", "carts"},
+		},
+		{
+			name:           "blue/green",
+			deploymentType: "blue_green_service",
+			matches:        []string{"carts-primary-5b8f7c9d4-x2x7k"},
+			noMatches:      []string{"carts-5b8f7c9d4-x2x7k", "carts-primary-db-5b8f7c9d4-x2x7k"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pod *promlabels.Matcher
+			for _, matcher := range podMatchers("sockshop", "dev", "carts", tt.deploymentType) {
+				if matcher.Name == "pod" {
+					pod = matcher
+				}
+			}
+			if pod == nil {
+				t.Fatal("expected a pod matcher")
+			}
+			for _, name := range tt.matches {
+				if !pod.Matches(name) {
+					t.Errorf("expected %s to match pod %s", pod.String(), name)
+				}
+			}
+			for _, name := range tt.noMatches {
+				if pod.Matches(name) {
+					t.Errorf("expected %s not to match pod %s", pod.String(), name)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkloadAndDefaultQueries(t *testing.T) {
+	config := &utils.ServiceConfig{
+		QueryRange:         "3m",
+		RequestsMetric:     "http_requests_total",
+		ResponseTimeMetric: "http_response_time_milliseconds_bucket",
+		ApdexThreshold:     500 * time.Millisecond,
+	}
+	profile, err := getMetricProfile(config, utils.MetricProfileDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query func() (string, error)
+		want  string
+	}{
+		{
+			name: "response time p99",
+			query: func() (string, error) {
+				return getDefaultResponseTimeQuery(config, profile, "sockshop", "dev", "carts", nil, "99")
+			},
+			want: `histogram_quantile(0.99,sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev"}[3m]))by(le))`,
+		},
+		{
+			name: "availability",
+			query: func() (string, error) {
+				return getDefaultAvailabilityQuery(config, profile, "sockshop", "dev", "carts", nil)
+			},
+			want: `sum(rate(http_requests_total{job="carts-sockshop-dev",status!~"5.."}[3m]))/sum(rate(http_requests_total{job="carts-sockshop-dev"}[3m]))`,
+		},
+		{
+			name: "apdex",
+			query: func() (string, error) {
+				return getDefaultApdexQuery(config, profile, "sockshop", "dev", "carts", nil)
+			},
+			want: `(sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev",le=~"500(\\.0+)?"}[3m]))+sum(rate(http_response_time_milliseconds_bucket{job="carts-sockshop-dev",le=~"2000(\\.0+)?"}[3m])))/2/sum(rate(http_response_time_milliseconds_count{job="carts-sockshop-dev"}[3m]))`,
+		},
+		{
+			name: "cpu saturation",
+			query: func() (string, error) {
+				return getCPUSaturationQuery(config, "sockshop", "dev", "carts", "direct")
+			},
+			want: `sum(rate(container_cpu_usage_seconds_total{container!="",container!="POD",namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+"}[3m]))/sum(kube_pod_container_resource_limits{namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+",resource="cpu"})`,
+		},
+		{
+			name: "memory saturation",
+			query: func() (string, error) {
+				return getMemorySaturationQuery("sockshop", "dev", "carts", "direct")
+			},
+			want: `sum(container_memory_working_set_bytes{container!="",container!="POD",namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+"})/sum(kube_pod_container_resource_limits{namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+",resource="memory"})`,
+		},
+		{
+			name: "pod restarts",
+			query: func() (string, error) {
+				return getPodRestartsQuery(config, "sockshop", "dev", "carts", "direct")
+			},
+			want: `sum(increase(kube_pod_container_status_restarts_total{namespace="sockshop-dev",pod=~"carts-[a-z0-9]+-[a-z0-9]+"}[3m]))`,
+		},
+		{
+			name: "ready replica ratio",
+			query: func() (string, error) {
+				return getReadyReplicaRatioQuery("sockshop", "dev", "carts", "direct")
+			},
+			want: `sum(kube_deployment_status_replicas_available{deployment="carts",namespace="sockshop-dev"})/sum(kube_deployment_spec_replicas{deployment="carts",namespace="sockshop-dev"})`,
+		},
+		{
+			name: "blue/green cpu saturation",
+			query: func() (string, error) {
+				return getCPUSaturationQuery(config, "sockshop", "prod", "carts", "blue_green_service")
+			},
+			want: `sum(rate(container_cpu_usage_seconds_total{container!="",container!="POD",namespace="sockshop-prod",pod=~"carts-primary-[a-z0-9]+-[a-z0-9]+"}[3m]))/sum(kube_pod_container_resource_limits{namespace="sockshop-prod",pod=~"carts-primary-[a-z0-9]+-[a-z0-9]+",resource="cpu"})`,
+		},
+		{
+			name: "blue/green ready replica ratio",
+			query: func() (string, error) {
+				return getReadyReplicaRatioQuery("sockshop", "prod", "carts", "blue_green_service")
+			},
+			want: `sum(kube_deployment_status_replicas_available{deployment="carts-primary",namespace="sockshop-prod"})/sum(kube_deployment_spec_replicas{deployment="carts-primary",namespace="sockshop-prod"})`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := tt.query()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, query)
+			}
+			if _, err := parser.ParseExpr(query); err != nil {
+				t.Errorf("invalid expression %s: %s", query, err.Error())
+			}
+		})
+	}
+}
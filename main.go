@@ -4,54 +4,69 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/keptn-contrib/prometheus-service/eventhandling"
+	"github.com/keptn-contrib/prometheus-service/utils"
 	"io/ioutil"
 	"net/http"
 	"os"
 
 	"github.com/cloudevents/sdk-go/pkg/cloudevents/client"
 	cloudeventshttp "github.com/cloudevents/sdk-go/pkg/cloudevents/transport/http"
-	"github.com/kelseyhightower/envconfig"
 	keptnutils "github.com/keptn/go-utils/pkg/lib"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type envConfig struct {
-	// Port on which to listen for cloudevents
-	Port int    `envconfig:"RCV_PORT" default:"8081"`
-	Path string `envconfig:"RCV_PATH" default:"/"`
-}
+// receiverPort is the port the CloudEvent receiver listens on, which does not change when the configuration is reloaded
+var receiverPort int
 
 type ceTest struct {
 	Specversion string `json:"specversion" yaml:"specversion"`
 }
 
 func main() {
-	// listen on port 8080 for any event
 	shkeptncontext := ""
 	logger := keptnutils.NewLogger(shkeptncontext, "", "prometheus-service")
-	logger.Debug("Starting server for receiving events on exposed port 8080")
-	http.HandleFunc("/", Handler)
-	go http.ListenAndServe(":8080", nil)
 
-	// listen on port 8081 for CloudEvent
-	var env envConfig
-	if err := envconfig.Process("", &env); err != nil {
-		logger.Error(fmt.Sprintf("Failed to process env var: %s", err))
+	utils.RegisterConfigFlags(flag.CommandLine)
+	flag.Parse()
+	config, err := utils.LoadConfig()
+	if err != nil {
+		logger.Error("Invalid configuration: " + err.Error())
+		os.Exit(1)
 	}
-	os.Exit(_main(os.Args[1:], env))
+	logger.Info("Configuration:\n" + config.Summary())
+	receiverPort = config.ReceiverPort
+	go eventhandling.WatchConfig(logger)
+	go eventhandling.ReconcileConfig(logger)
+	go eventhandling.WatchMonitoringInputs(logger)
+
+	// listen on the exposed port for any event
+	logger.Debug(fmt.Sprintf("Starting server for receiving events on exposed port %d", config.Port))
+	http.HandleFunc("/", Handler)
+	http.HandleFunc("/config/revisions", eventhandling.HandleConfigRevisions)
+	http.HandleFunc("/config/rollback", eventhandling.HandleConfigRollback)
+	http.HandleFunc("/config/plan", eventhandling.HandleConfigPlan)
+	http.HandleFunc("/config/reload", eventhandling.HandleConfigReload)
+	http.HandleFunc("/uninstall", eventhandling.HandleUninstall)
+	http.Handle("/metrics", promhttp.Handler())
+	go http.ListenAndServe(fmt.Sprintf(":%d", config.Port), nil)
+
+	// listen on the receiver port for CloudEvent
+	os.Exit(_main(flag.Args(), config))
 }
 
-func _main(args []string, env envConfig) int {
+func _main(args []string, config *utils.ServiceConfig) int {
 	shkeptncontext := ""
 	logger := keptnutils.NewLogger(shkeptncontext, "", "prometheus-service")
 
 	ctx := context.Background()
 
 	t, err := cloudeventshttp.New(
-		cloudeventshttp.WithPort(env.Port),
-		cloudeventshttp.WithPath(env.Path),
+		cloudeventshttp.WithPort(config.ReceiverPort),
+		cloudeventshttp.WithPath(config.ReceiverPath),
 	)
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to create transport: %v", err))
@@ -61,7 +76,7 @@ func _main(args []string, env envConfig) int {
 	if err != nil {
 		logger.Error(fmt.Sprintf("Failed to create client: %v", err))
 	}
-	logger.Debug("Starting server for receiving Cloud Events on 8081 for internal use")
+	logger.Debug(fmt.Sprintf("Starting server for receiving Cloud Events on %d for internal use", config.ReceiverPort))
 	logger.Error(fmt.Sprintf("Failed to start receiver: %s", c.StartReceiver(ctx, eventhandling.GotEvent)))
 
 	return 0
@@ -80,11 +95,11 @@ func Handler(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// check event whether event contains specversion to forward it to 8081; otherwise process it as prometheus alert
+	// check event whether event contains specversion to forward it to the receiver; otherwise process it as prometheus alert
 	if json.Unmarshal(body, &event) != nil || event.Specversion == "" {
 		eventhandling.ProcessAndForwardAlertEvent(rw, body, logger, shkeptncontext)
 	} else {
-		proxyReq, err := http.NewRequest(req.Method, "http://localhost:8081", bytes.NewReader(body))
+		proxyReq, err := http.NewRequest(req.Method, fmt.Sprintf("http://localhost:%d", receiverPort), bytes.NewReader(body))
 		proxyReq.Header.Set("Content-Type", "application/cloudevents+json")
 		resp, err := http.DefaultClient.Do(proxyReq)
 		if err != nil {
@@ -97,7 +112,7 @@ func Handler(rw http.ResponseWriter, req *http.Request) {
 			logger.Error(fmt.Sprintf("Could not send cloud event: %s", err.Error()))
 			rw.WriteHeader(500)
 		} else {
-			logger.Debug("Event successfully sent to port 8081")
+			logger.Debug("Event successfully sent to the receiver")
 			rw.WriteHeader(201)
 		}
 	}
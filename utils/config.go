@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const defaultPrometheusImage = "prom/prometheus"
+const defaultPrometheusVersion = "v2.12.0"
+const defaultAlertManagerImage = "prom/alertmanager"
+const defaultAlertManagerVersion = "v0.20.0"
+
+// PrometheusConfig holds the names of the Prometheus and Alertmanager resources managed by the prometheus-service
+type PrometheusConfig struct {
+	Namespace       string `envconfig:"PROMETHEUS_NAMESPACE" default:"monitoring"`
+	ServiceName     string `envconfig:"PROMETHEUS_SERVICE" default:"prometheus-service"`
+	DeploymentName  string `envconfig:"PROMETHEUS_DEPLOYMENT" default:"prometheus-deployment"`
+	ConfigMapName   string `envconfig:"PROMETHEUS_CONFIGMAP" default:"prometheus-server-conf"`
+	ConfigFileKey   string `envconfig:"PROMETHEUS_CONFIG_FILE" default:"prometheus.yml"`
+	RulesFileKey    string `envconfig:"PROMETHEUS_RULES_FILE" default:"prometheus.rules"`
+	Selector        string `envconfig:"PROMETHEUS_SELECTOR" default:"app=prometheus-server"`
+	ClusterRoleName string `envconfig:"PROMETHEUS_CLUSTER_ROLE" default:"prometheus"`
+	// Endpoint overrides the address of the Prometheus HTTP API, which is derived from the service otherwise
+	Endpoint string `envconfig:"PROMETHEUS_ENDPOINT"`
+	// StatusConfigMapName is the config map recording the version of the bundled Prometheus stack
+	StatusConfigMapName string `envconfig:"PROMETHEUS_STATUS_CONFIGMAP" default:"prometheus-service-status"`
+
+	AlertManagerNamespace      string `envconfig:"ALERT_MANAGER_NAMESPACE" default:"monitoring"`
+	AlertManagerServiceName    string `envconfig:"ALERT_MANAGER_SERVICE" default:"alertmanager"`
+	AlertManagerDeploymentName string `envconfig:"ALERT_MANAGER_DEPLOYMENT" default:"alertmanager"`
+	AlertManagerConfigMapName  string `envconfig:"ALERT_MANAGER_CONFIGMAP" default:"alertmanager-config"`
+	AlertManagerTemplatesName  string `envconfig:"ALERT_MANAGER_TEMPLATES_CONFIGMAP" default:"alertmanager-templates"`
+	AlertManagerSelector       string `envconfig:"ALERT_MANAGER_SELECTOR" default:"app=alertmanager"`
+	AlertManagerEndpoint       string `envconfig:"ALERT_MANAGER_ENDPOINT"`
+
+	// Prometheus holds the settings of the bundled Prometheus deployment
+	Prometheus    WorkloadConfig `envconfig:"PROMETHEUS"`
+	RetentionTime string         `envconfig:"PROMETHEUS_RETENTION_TIME"`
+	RetentionSize string         `envconfig:"PROMETHEUS_RETENTION_SIZE"`
+	// StorageSize enables a persistent volume claim of the given size for the Prometheus data
+	StorageSize  string `envconfig:"PROMETHEUS_STORAGE_SIZE"`
+	StorageClass string `envconfig:"PROMETHEUS_STORAGE_CLASS"`
+
+	// AlertManager holds the settings of the bundled Alertmanager deployment
+	AlertManager WorkloadConfig `envconfig:"ALERT_MANAGER"`
+}
+
+// WorkloadConfig holds the settings of a deployment of the bundled Prometheus stack.
+// Maps are given as comma separated key:value pairs, e.g. PROMETHEUS_REQUESTS=cpu:500m,memory:1Gi
+type WorkloadConfig struct {
+	Image        string            `envconfig:"IMAGE"`
+	Version      string            `envconfig:"VERSION"`
+	Replicas     int32             `envconfig:"REPLICAS" default:"1"`
+	Requests     map[string]string `envconfig:"REQUESTS"`
+	Limits       map[string]string `envconfig:"LIMITS"`
+	NodeSelector map[string]string `envconfig:"NODE_SELECTOR"`
+	// Tolerations are given as JSON list, e.g. [{"key":"dedicated","operator":"Exists"}]
+	Tolerations Tolerations `envconfig:"TOLERATIONS"`
+}
+
+// Tolerations can be decoded from a JSON list
+type Tolerations []v1.Toleration
+
+// Decode implements envconfig.Decoder
+func (t *Tolerations) Decode(value string) error {
+	return json.Unmarshal([]byte(value), t)
+}
+
+// ImageName returns the image including its version
+func (w *WorkloadConfig) ImageName() string {
+	return w.Image + ":" + w.Version
+}
+
+// ResourceRequirements returns the requests and limits of the containers
+func (w *WorkloadConfig) ResourceRequirements() v1.ResourceRequirements {
+	requests, _ := toResourceList(w.Requests)
+	limits, _ := toResourceList(w.Limits)
+	return v1.ResourceRequirements{Requests: requests, Limits: limits}
+}
+
+func (w *WorkloadConfig) validate(name string) error {
+	if w.Replicas < 1 {
+		return fmt.Errorf("Invalid number of %s replicas: %d", name, w.Replicas)
+	}
+	if _, err := toResourceList(w.Requests); err != nil {
+		return fmt.Errorf("Invalid %s resource requests: %s", name, err.Error())
+	}
+	if _, err := toResourceList(w.Limits); err != nil {
+		return fmt.Errorf("Invalid %s resource limits: %s", name, err.Error())
+	}
+	return nil
+}
+
+func toResourceList(resources map[string]string) (v1.ResourceList, error) {
+	if len(resources) == 0 {
+		return nil, nil
+	}
+	list := v1.ResourceList{}
+	for name, value := range resources {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		list[v1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+// GetPrometheusConfig returns the Prometheus settings of the current service configuration
+func GetPrometheusConfig() (*PrometheusConfig, error) {
+	config, err := GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &config.PrometheusConfig, nil
+}
+
+// validate checks the selectors, workloads and storage size and fills in the default images
+func (c *PrometheusConfig) validate() error {
+	if _, err := labels.ConvertSelectorToLabelsMap(c.Selector); err != nil {
+		return fmt.Errorf("Invalid Prometheus selector %s: %s", c.Selector, err.Error())
+	}
+	if _, err := labels.ConvertSelectorToLabelsMap(c.AlertManagerSelector); err != nil {
+		return fmt.Errorf("Invalid Alertmanager selector %s: %s", c.AlertManagerSelector, err.Error())
+	}
+	if c.Prometheus.Image == "" {
+		c.Prometheus.Image = defaultPrometheusImage
+	}
+	if c.Prometheus.Version == "" {
+		c.Prometheus.Version = defaultPrometheusVersion
+	}
+	if c.AlertManager.Image == "" {
+		c.AlertManager.Image = defaultAlertManagerImage
+	}
+	if c.AlertManager.Version == "" {
+		c.AlertManager.Version = defaultAlertManagerVersion
+	}
+	if err := c.Prometheus.validate("Prometheus"); err != nil {
+		return err
+	}
+	if err := c.AlertManager.validate("Alertmanager"); err != nil {
+		return err
+	}
+	if c.StorageSize != "" {
+		if _, err := resource.ParseQuantity(c.StorageSize); err != nil {
+			return fmt.Errorf("Invalid Prometheus storage size %s: %s", c.StorageSize, err.Error())
+		}
+	}
+	return nil
+}
+
+// SelectorLabels returns the labels of the Prometheus pods
+func (c *PrometheusConfig) SelectorLabels() map[string]string {
+	selectorLabels, _ := labels.ConvertSelectorToLabelsMap(c.Selector)
+	return selectorLabels
+}
+
+// AlertManagerSelectorLabels returns the labels of the Alertmanager pods
+func (c *PrometheusConfig) AlertManagerSelectorLabels() map[string]string {
+	selectorLabels, _ := labels.ConvertSelectorToLabelsMap(c.AlertManagerSelector)
+	return selectorLabels
+}
+
+// AlertManagerTarget returns the address Prometheus sends alerts to
+func (c *PrometheusConfig) AlertManagerTarget() string {
+	return fmt.Sprintf("%s.%s.svc:9093", c.AlertManagerServiceName, c.AlertManagerNamespace)
+}
+
+// PrometheusURL returns the address of the HTTP API of the bundled Prometheus
+func (c *PrometheusConfig) PrometheusURL() string {
+	if c.Endpoint != "" {
+		return strings.TrimSuffix(c.Endpoint, "/")
+	}
+	return fmt.Sprintf("http://%s.%s.svc:8080", c.ServiceName, c.Namespace)
+}
+
+// AlertManagerURL returns the address of the HTTP API of the bundled Alertmanager
+func (c *PrometheusConfig) AlertManagerURL() string {
+	if c.AlertManagerEndpoint != "" {
+		return strings.TrimSuffix(c.AlertManagerEndpoint, "/")
+	}
+	return "http://" + c.AlertManagerTarget()
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"path/filepath"
+	"sync"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var kubeClientMutex sync.Mutex
+var kubeClient kubernetes.Interface
+var dynamicClient dynamic.Interface
+
+// GetKubeRestConfig returns the configuration of the Kubernetes API, read from the kubeconfig file given by
+// KUBECONFIG and KUBE_CONTEXT when running outside of the cluster
+func GetKubeRestConfig() (*rest.Config, error) {
+	config, err := GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.KubeConfig == "" && config.KubeContext == "" {
+		return rest.InClusterConfig()
+	}
+	// KUBECONFIG may contain a list of files, which are merged by the default loading rules
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if config.KubeConfig != "" {
+		loadingRules.Precedence = filepath.SplitList(config.KubeConfig)
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: config.KubeContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+// GetKubeClient returns the client for all Kubernetes API requests of the service
+func GetKubeClient() (kubernetes.Interface, error) {
+	kubeClientMutex.Lock()
+	defer kubeClientMutex.Unlock()
+	if kubeClient != nil {
+		return kubeClient, nil
+	}
+	config, err := GetKubeRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient = client
+	return kubeClient, nil
+}
+
+// SetKubeClient replaces the Kubernetes client, e.g., with a fake clientset
+func SetKubeClient(client kubernetes.Interface) {
+	kubeClientMutex.Lock()
+	defer kubeClientMutex.Unlock()
+	kubeClient = client
+}
+
+// GetDynamicClient returns the client for custom resources, e.g., of the Prometheus Operator
+func GetDynamicClient() (dynamic.Interface, error) {
+	kubeClientMutex.Lock()
+	defer kubeClientMutex.Unlock()
+	if dynamicClient != nil {
+		return dynamicClient, nil
+	}
+	config, err := GetKubeRestConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient = client
+	return dynamicClient, nil
+}
+
+// SetDynamicClient replaces the client for custom resources, e.g., with a fake dynamic client
+func SetDynamicClient(client dynamic.Interface) {
+	kubeClientMutex.Lock()
+	defer kubeClientMutex.Unlock()
+	dynamicClient = client
+}
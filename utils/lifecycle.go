@@ -0,0 +1,332 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StackVersion is the version of the templates of the bundled Prometheus stack. It has to be increased whenever the
+// generated objects change in a way that requires existing installations to be upgraded, together with a migration
+// of the Prometheus configuration in prometheusConfigMigrations if needed.
+const StackVersion = 2
+
+// legacyStackVersion is the version of installations created before the stack version was tracked
+const legacyStackVersion = 1
+
+// ManagedByLabel marks the objects created by the prometheus-service
+const ManagedByLabel = "app.kubernetes.io/managed-by"
+const managedByValue = "prometheus-service"
+
+// StackVersionLabel holds the stack version an object has been created or updated with
+const StackVersionLabel = "prometheus-service.keptn.sh/stack-version"
+
+const (
+	statusStackVersionKey      = "stackVersion"
+	statusPrometheusImageKey   = "prometheusImage"
+	statusAlertManagerImageKey = "alertManagerImage"
+	statusInstalledKey         = "installed"
+	statusUpdatedKey           = "updated"
+	statusSpecHashKey          = "specHash"
+	statusAlertManagerHashKey  = "alertManagerConfigHash"
+)
+
+// alertManagerConfigKey is the key of the configuration in the Alertmanager config map
+const alertManagerConfigKey = "config.yml"
+
+// configHashAnnotation holds the hash of the Alertmanager configuration the pods have been started with
+const configHashAnnotation = "prometheus-service.keptn.sh/config-hash"
+
+// prometheusConfigMigrations migrate the Prometheus configuration created by the templates of a stack version to the
+// next version. The key is the version that is migrated from.
+var prometheusConfigMigrations = map[int]func(p *PrometheusHelper, config string) string{
+	// version 1 used fixed names for the rule file and the Alertmanager
+	legacyStackVersion: func(p *PrometheusHelper, config string) string {
+		return strings.NewReplacer(
+			"/etc/prometheus/prometheus.rules", "/etc/prometheus/"+p.Config.RulesFileKey,
+			"alertmanager.monitoring.svc:9093", p.Config.AlertManagerTarget(),
+		).Replace(config)
+	},
+}
+
+// InstallationStatus describes the bundled Prometheus stack installed by the prometheus-service
+type InstallationStatus struct {
+	StackVersion      int    `json:"stackVersion"`
+	PrometheusImage   string `json:"prometheusImage,omitempty"`
+	AlertManagerImage string `json:"alertManagerImage,omitempty"`
+	Installed         string `json:"installed,omitempty"`
+	Updated           string `json:"updated,omitempty"`
+	// SpecHash is the hash of the rendered deployments, which changes with the workload settings, e.g.
+	// PROMETHEUS_REPLICAS or PROMETHEUS_RETENTION_TIME
+	SpecHash string `json:"specHash,omitempty"`
+	// AlertManagerConfigHash is the hash of the rendered Alertmanager configuration, which changes with
+	// ALERT_WEBHOOK_URL
+	AlertManagerConfigHash string `json:"alertManagerConfigHash,omitempty"`
+}
+
+// NeedsUpgrade returns true if the installation has been created with other templates, images or settings than the
+// current ones
+func (s *InstallationStatus) NeedsUpgrade(p *PrometheusHelper) bool {
+	specHash, alertManagerConfigHash, err := p.getInstallationHashes()
+	return err != nil ||
+		s.StackVersion < StackVersion ||
+		s.PrometheusImage != p.Config.Prometheus.ImageName() ||
+		s.AlertManagerImage != p.Config.AlertManager.ImageName() ||
+		s.SpecHash != specHash ||
+		s.AlertManagerConfigHash != alertManagerConfigHash
+}
+
+// getInstallationHashes returns the hashes of the deployments and the Alertmanager configuration rendered from the
+// current settings
+func (p *PrometheusHelper) getInstallationHashes() (string, string, error) {
+	spec, err := json.Marshal([]interface{}{p.getPrometheusDeployment().Spec, p.getAlertManagerDeployment().Spec})
+	if err != nil {
+		return "", "", err
+	}
+	alertManagerConfig, err := p.renderAlertManagerConfig()
+	if err != nil {
+		return "", "", err
+	}
+	return hashOf(string(spec)), hashOf(alertManagerConfig), nil
+}
+
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsManaged returns true if the labels mark an object created by the prometheus-service
+func IsManaged(labels map[string]string) bool {
+	return labels[ManagedByLabel] == managedByValue
+}
+
+// managedLabels adds the managed-by and stack version labels to the given labels
+func (p *PrometheusHelper) managedLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[ManagedByLabel] = managedByValue
+	labels[StackVersionLabel] = strconv.Itoa(StackVersion)
+	return labels
+}
+
+// checkManaged returns an error if an existing object has not been created by the prometheus-service, so objects of
+// other installations are never overwritten
+func (p *PrometheusHelper) checkManaged(kind string, meta metav1.ObjectMeta) error {
+	if IsManaged(meta.Labels) || p.AdoptExisting {
+		return nil
+	}
+	if meta.Namespace == "" {
+		return fmt.Errorf("%s %s exists but has not been created by the prometheus-service", kind, meta.Name)
+	}
+	return fmt.Errorf("%s %s/%s exists but has not been created by the prometheus-service", kind, meta.Namespace, meta.Name)
+}
+
+// migratePrometheusConfig applies the migrations of the Prometheus configuration from the given stack version
+func (p *PrometheusHelper) migratePrometheusConfig(config string, fromVersion int) string {
+	for version := fromVersion; version < StackVersion; version++ {
+		if migrate, ok := prometheusConfigMigrations[version]; ok {
+			config = migrate(p, config)
+		}
+	}
+	return config
+}
+
+// GetInstallationStatus returns the status of the bundled Prometheus stack. Installations created before the stack
+// version was tracked are reported with version 1. nil is returned if the stack has not been installed.
+func (p *PrometheusHelper) GetInstallationStatus() (*InstallationStatus, error) {
+	cm, err := p.KubeApi.CoreV1().ConfigMaps(p.Config.Namespace).Get(p.Config.StatusConfigMapName, metav1.GetOptions{})
+	if err == nil {
+		version, err := strconv.Atoi(cm.Data[statusStackVersionKey])
+		if err != nil {
+			return nil, fmt.Errorf("invalid stack version in config map %s/%s: %s", cm.Namespace, cm.Name, err.Error())
+		}
+		return &InstallationStatus{
+			StackVersion:           version,
+			PrometheusImage:        cm.Data[statusPrometheusImageKey],
+			AlertManagerImage:      cm.Data[statusAlertManagerImageKey],
+			Installed:              cm.Data[statusInstalledKey],
+			Updated:                cm.Data[statusUpdatedKey],
+			SpecHash:               cm.Data[statusSpecHashKey],
+			AlertManagerConfigHash: cm.Data[statusAlertManagerHashKey],
+		}, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	legacy, err := p.isLegacyInstallation()
+	if err != nil || !legacy {
+		return nil, err
+	}
+	return &InstallationStatus{StackVersion: legacyStackVersion}, nil
+}
+
+// isLegacyInstallation detects a Prometheus deployment created by a version of the prometheus-service that did not
+// label its objects
+func (p *PrometheusHelper) isLegacyInstallation() (bool, error) {
+	deployment, err := p.KubeApi.AppsV1().Deployments(p.Config.Namespace).Get(p.Config.DeploymentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if IsManaged(deployment.Labels) {
+		return false, nil
+	}
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name == "prometheus" && strings.HasPrefix(container.Image, defaultPrometheusImage+":") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// StoreInstallationStatus records the current stack version and images
+func (p *PrometheusHelper) StoreInstallationStatus() error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	specHash, alertManagerConfigHash, err := p.getInstallationHashes()
+	if err != nil {
+		return err
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.Config.StatusConfigMapName,
+			Namespace: p.Config.Namespace,
+			Labels:    p.managedLabels(nil),
+		},
+		Data: map[string]string{
+			statusStackVersionKey:      strconv.Itoa(StackVersion),
+			statusPrometheusImageKey:   p.Config.Prometheus.ImageName(),
+			statusAlertManagerImageKey: p.Config.AlertManager.ImageName(),
+			statusInstalledKey:         now,
+			statusUpdatedKey:           now,
+			statusSpecHashKey:          specHash,
+			statusAlertManagerHashKey:  alertManagerConfigHash,
+		},
+	}
+	existing, err := p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Get(cm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if installed := existing.Data[statusInstalledKey]; installed != "" {
+		cm.Data[statusInstalledKey] = installed
+	}
+	cm.ResourceVersion = existing.ResourceVersion
+	_, err = p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Update(cm)
+	return err
+}
+
+// Uninstall removes the objects of the bundled Prometheus stack that have been created by the prometheus-service.
+// The persistent volume claim holding the Prometheus data is only removed if purgeData is set. Namespaces are kept.
+// The names of the removed objects are returned.
+func (p *PrometheusHelper) Uninstall(purgeData bool) ([]string, error) {
+	var removed []string
+	remove := func(kind string, namespace string, name string, get func() (metav1.Object, error), del func() error) error {
+		obj, err := get()
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !IsManaged(obj.GetLabels()) {
+			return nil
+		}
+		if err := del(); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete %s %s: %s", kind, name, err.Error())
+		}
+		if namespace != "" {
+			name = namespace + "/" + name
+		}
+		removed = append(removed, kind+" "+name)
+		return nil
+	}
+	core := p.KubeApi.CoreV1()
+	apps := p.KubeApi.AppsV1()
+	rbac := p.KubeApi.RbacV1beta1()
+
+	for _, d := range []struct{ namespace, name string }{
+		{p.Config.Namespace, p.Config.DeploymentName},
+		{p.Config.AlertManagerNamespace, p.Config.AlertManagerDeploymentName},
+	} {
+		d := d
+		err := remove("deployment", d.namespace, d.name,
+			func() (metav1.Object, error) { return apps.Deployments(d.namespace).Get(d.name, metav1.GetOptions{}) },
+			func() error { return apps.Deployments(d.namespace).Delete(d.name, &metav1.DeleteOptions{}) })
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	for _, s := range []struct{ namespace, name string }{
+		{p.Config.Namespace, p.Config.ServiceName},
+		{p.Config.AlertManagerNamespace, p.Config.AlertManagerServiceName},
+	} {
+		s := s
+		err := remove("service", s.namespace, s.name,
+			func() (metav1.Object, error) { return core.Services(s.namespace).Get(s.name, metav1.GetOptions{}) },
+			func() error { return core.Services(s.namespace).Delete(s.name, &metav1.DeleteOptions{}) })
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	// config maps include the revisions of the Prometheus configuration and the installation status
+	namespaces := []string{p.Config.Namespace}
+	if p.Config.AlertManagerNamespace != p.Config.Namespace {
+		namespaces = append(namespaces, p.Config.AlertManagerNamespace)
+	}
+	for _, namespace := range namespaces {
+		cms, err := core.ConfigMaps(namespace).List(metav1.ListOptions{LabelSelector: ManagedByLabel + "=" + managedByValue})
+		if err != nil {
+			return removed, err
+		}
+		for _, cm := range cms.Items {
+			namespace, name := cm.Namespace, cm.Name
+			err := remove("config map", namespace, name,
+				func() (metav1.Object, error) { return core.ConfigMaps(namespace).Get(name, metav1.GetOptions{}) },
+				func() error { return core.ConfigMaps(namespace).Delete(name, &metav1.DeleteOptions{}) })
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+
+	if purgeData {
+		namespace, name := p.Config.Namespace, p.getStorageClaimName()
+		err := remove("persistent volume claim", namespace, name,
+			func() (metav1.Object, error) {
+				return core.PersistentVolumeClaims(namespace).Get(name, metav1.GetOptions{})
+			},
+			func() error { return core.PersistentVolumeClaims(namespace).Delete(name, &metav1.DeleteOptions{}) })
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	name := p.Config.ClusterRoleName
+	err := remove("cluster role binding", "", name,
+		func() (metav1.Object, error) { return rbac.ClusterRoleBindings().Get(name, metav1.GetOptions{}) },
+		func() error { return rbac.ClusterRoleBindings().Delete(name, &metav1.DeleteOptions{}) })
+	if err != nil {
+		return removed, err
+	}
+	err = remove("cluster role", "", name,
+		func() (metav1.Object, error) { return rbac.ClusterRoles().Get(name, metav1.GetOptions{}) },
+		func() error { return rbac.ClusterRoles().Delete(name, &metav1.DeleteOptions{}) })
+	return removed, err
+}
@@ -1,13 +1,17 @@
 package utils
 
 import (
+	"strings"
+
 	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/api/rbac/v1beta1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -31,19 +35,19 @@ route:
 receivers:
 - name: keptn_integration
   webhook_configs:
-  - url: http://prometheus-service.keptn.svc.cluster.local:8080`
+  - url: $WEBHOOK_URL`
 
 const prometheusYml = `global:
   scrape_interval: 5s
   evaluation_interval: 5s
 rule_files:
-  - /etc/prometheus/prometheus.rules
+  - /etc/prometheus/$RULES_FILE
 alerting:
   alertmanagers:
   - scheme: http
     static_configs:
     - targets:
-      - "alertmanager.monitoring.svc:9093"
+      - "$ALERT_MANAGER_TARGET"
 
 scrape_configs:
   - job_name: 'kubernetes-apiservers'
@@ -333,74 +337,121 @@ const alertManagerSlackTemplate = `{{ define "slack.devops.text" }}
 {{ end }}`
 
 type PrometheusHelper struct {
-	KubeApi *kubernetes.Clientset
+	KubeApi kubernetes.Interface
+	Config  *PrometheusConfig
+	// AdoptExisting allows to update objects that do not carry the managed-by label, which is needed to upgrade
+	// installations created before the stack version was tracked
+	AdoptExisting bool
 }
 
 // NewPrometheusHelper creates a new PrometheusHelper
 func NewPrometheusHelper() (*PrometheusHelper, error) {
-
-	config, err := rest.InClusterConfig()
+	prometheusConfig, err := GetPrometheusConfig()
 	if err != nil {
 		return nil, err
 	}
-	clientset, err := kubernetes.NewForConfig(config)
 
+	clientset, err := GetKubeClient()
 	if err != nil {
 		return nil, err
 	}
 
-	return &PrometheusHelper{KubeApi: clientset}, nil
+	return &PrometheusHelper{KubeApi: clientset, Config: prometheusConfig}, nil
 }
 
-// CreateOrUpdatePrometheusNamespace creates or updates the Prometheus namespace
+// CreateOrUpdatePrometheusNamespace creates or updates the Prometheus and Alertmanager namespaces
 func (p *PrometheusHelper) CreateOrUpdatePrometheusNamespace() error {
+	if err := p.createOrUpdateNamespace(p.Config.Namespace); err != nil {
+		return err
+	}
+	if p.Config.AlertManagerNamespace != p.Config.Namespace {
+		return p.createOrUpdateNamespace(p.Config.AlertManagerNamespace)
+	}
+	return nil
+}
+
+func (p *PrometheusHelper) createOrUpdateNamespace(name string) error {
+	// existing namespaces are shared with other workloads and are left untouched
+	_, err := p.KubeApi.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
 	namespace := &v1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "monitoring",
+			Name: name,
 		},
 	}
-	_, err := p.KubeApi.CoreV1().Namespaces().Create(namespace)
+	_, err = p.KubeApi.CoreV1().Namespaces().Create(namespace)
+	return err
+}
 
-	if err != nil {
-		_, err = p.KubeApi.CoreV1().Namespaces().Update(namespace)
-		if err != nil {
+// CreateOrUpdatePrometheusConfigMap creates the Prometheus config map. The configuration of an existing config map
+// contains the scrape jobs and rules of the configured services, so it is kept and migrated from the given stack
+// version instead of being replaced.
+func (p *PrometheusHelper) CreateOrUpdatePrometheusConfigMap(fromVersion int) error {
+	existing, err := p.KubeApi.CoreV1().ConfigMaps(p.Config.Namespace).Get(p.Config.ConfigMapName, metav1.GetOptions{})
+	if err == nil {
+		if err := p.checkManaged("config map", existing.ObjectMeta); err != nil {
 			return err
 		}
+		if existing.Data == nil {
+			existing.Data = map[string]string{}
+		}
+		existing.Data[p.Config.ConfigFileKey] = p.migratePrometheusConfig(existing.Data[p.Config.ConfigFileKey], fromVersion)
+		existing.Labels = p.managedLabels(existing.Labels)
+		_, err = p.KubeApi.CoreV1().ConfigMaps(existing.Namespace).Update(existing)
+		return err
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
 	}
-	return nil
-}
 
-// CreateOrUpdatePrometheusConfigMap creates or updates the Prometheus config map
-func (p *PrometheusHelper) CreateOrUpdatePrometheusConfigMap() error {
 	cm := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "prometheus-server-conf",
-			Namespace: "monitoring",
+			Name:      p.Config.ConfigMapName,
+			Namespace: p.Config.Namespace,
 			Labels:    map[string]string{},
 		},
 		Data: map[string]string{},
 	}
-	cm.ObjectMeta.Labels["name"] = "prometheus-server-conf"
+	cm.ObjectMeta.Labels["name"] = p.Config.ConfigMapName
 
-	var configYaml interface{}
-	err := yaml.Unmarshal([]byte(prometheusYml), &configYaml)
+	config, err := p.RenderPrometheusConfig()
 	if err != nil {
 		return err
 	}
+	cm.Data[p.Config.ConfigFileKey] = config
+
+	return p.createOrUpdateConfigMap(cm)
+}
+
+// RenderPrometheusConfig returns the initial prometheus.yml of the bundled Prometheus
+func (p *PrometheusHelper) RenderPrometheusConfig() (string, error) {
+	config := strings.NewReplacer(
+		"$RULES_FILE", p.Config.RulesFileKey,
+		"$ALERT_MANAGER_TARGET", p.Config.AlertManagerTarget(),
+	).Replace(prometheusYml)
+
+	var configYaml interface{}
+	err := yaml.Unmarshal([]byte(config), &configYaml)
+	if err != nil {
+		return "", err
+	}
 	yamlString, err := yaml.Marshal(configYaml)
 	if err != nil {
-		return err
+		return "", err
 	}
-	cm.Data["prometheus.yml"] = string(yamlString)
-
-	return p.createOrUpdateConfigMap(cm)
+	return string(yamlString), nil
 }
 
-// CreateOrUpdatePrometheusConfigMap creates or updates the Prometheus config map
+// CreateOrUpdatePrometheusClusterRole creates or updates the cluster role and binding of Prometheus
 func (p *PrometheusHelper) CreateOrUpdatePrometheusClusterRole() error {
 	role := &v1beta1.ClusterRole{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "prometheus",
+			Name: p.Config.ClusterRoleName,
 		},
 		Rules: []v1beta1.PolicyRule{
 			{
@@ -419,88 +470,201 @@ func (p *PrometheusHelper) CreateOrUpdatePrometheusClusterRole() error {
 			},
 		},
 	}
-	_, err := p.KubeApi.RbacV1beta1().ClusterRoles().Create(role)
-	if err != nil {
-		_, err := p.KubeApi.RbacV1beta1().ClusterRoles().Update(role)
-		if err != nil {
+	role.Labels = p.managedLabels(nil)
+	existingRole, err := p.KubeApi.RbacV1beta1().ClusterRoles().Get(role.Name, metav1.GetOptions{})
+	if err == nil {
+		if err := p.checkManaged("cluster role", existingRole.ObjectMeta); err != nil {
 			return err
 		}
+		role.ResourceVersion = existingRole.ResourceVersion
+		_, err = p.KubeApi.RbacV1beta1().ClusterRoles().Update(role)
+	} else if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.RbacV1beta1().ClusterRoles().Create(role)
+	}
+	if err != nil {
+		return err
 	}
 
 	binding := &v1beta1.ClusterRoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "prometheus",
+			Name: p.Config.ClusterRoleName,
 		},
 		Subjects: []v1beta1.Subject{
 			{
 				Kind:      "ServiceAccount",
 				Name:      "default",
-				Namespace: "monitoring",
+				Namespace: p.Config.Namespace,
 			},
 		},
 		RoleRef: v1beta1.RoleRef{
 			APIGroup: "rbac.authorization.k8s.io",
 			Kind:     "ClusterRole",
-			Name:     "prometheus",
+			Name:     p.Config.ClusterRoleName,
 		},
 	}
-	_, err = p.KubeApi.RbacV1beta1().ClusterRoleBindings().Create(binding)
-
-	if err != nil {
-		_, err = p.KubeApi.RbacV1beta1().ClusterRoleBindings().Update(binding)
-		if err != nil {
+	binding.Labels = p.managedLabels(nil)
+	existingBinding, err := p.KubeApi.RbacV1beta1().ClusterRoleBindings().Get(binding.Name, metav1.GetOptions{})
+	if err == nil {
+		if err := p.checkManaged("cluster role binding", existingBinding.ObjectMeta); err != nil {
 			return err
 		}
+		binding.ResourceVersion = existingBinding.ResourceVersion
+		_, err = p.KubeApi.RbacV1beta1().ClusterRoleBindings().Update(binding)
+	} else if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.RbacV1beta1().ClusterRoleBindings().Create(binding)
 	}
+	return err
+}
 
-	return nil
+// CreateOrUpdatePrometheusStorage creates the persistent volume claim for the Prometheus data if a storage size
+// is configured
+func (p *PrometheusHelper) CreateOrUpdatePrometheusStorage() error {
+	if p.Config.StorageSize == "" {
+		return nil
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.getStorageClaimName(),
+			Namespace: p.Config.Namespace,
+			Labels:    p.managedLabels(nil),
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: resource.MustParse(p.Config.StorageSize),
+				},
+			},
+		},
+	}
+	if p.Config.StorageClass != "" {
+		pvc.Spec.StorageClassName = &p.Config.StorageClass
+	}
+
+	// the spec of an existing claim is immutable, so it is only created
+	_, err := p.KubeApi.CoreV1().PersistentVolumeClaims(pvc.Namespace).Get(pvc.Name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	_, err = p.KubeApi.CoreV1().PersistentVolumeClaims(pvc.Namespace).Create(pvc)
+	return err
+}
+
+func (p *PrometheusHelper) getStorageClaimName() string {
+	return p.Config.DeploymentName + "-storage"
 }
 
-// CreateOrUpdatePrometheusDeployment creates or updates the Prometheus config map
+func (p *PrometheusHelper) getPrometheusArgs() []string {
+	args := []string{"--config.file=/etc/prometheus/" + p.Config.ConfigFileKey, "--storage.tsdb.path=/prometheus/"}
+	if p.Config.RetentionTime != "" {
+		args = append(args, "--storage.tsdb.retention.time="+p.Config.RetentionTime)
+	}
+	if p.Config.RetentionSize != "" {
+		args = append(args, "--storage.tsdb.retention.size="+p.Config.RetentionSize)
+	}
+	return args
+}
+
+// CreateOrUpdatePrometheusDeployment creates or updates the Prometheus deployment and service
 func (p *PrometheusHelper) CreateOrUpdatePrometheusDeployment() error {
+	err := p.createOrUpdateDeployment(p.getPrometheusDeployment())
+	if err != nil {
+		return err
+	}
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.Config.ServiceName,
+			Namespace: p.Config.Namespace,
+			Annotations: map[string]string{
+				"prometheus.io/scrape": "true",
+				"prometheus.io/path":   "/",
+				"prometheus.io.port":   "8080",
+			},
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{
+					Port: 8080,
+					TargetPort: intstr.IntOrString{
+						IntVal: 9090,
+						Type:   intstr.Int,
+					},
+					NodePort: 30000,
+				},
+			},
+			Selector: p.Config.SelectorLabels(),
+			Type:     "NodePort",
+		},
+	}
+	return p.createOrUpdateService(service)
+}
+
+// getPrometheusDeployment returns the Prometheus deployment rendered from the current settings
+func (p *PrometheusHelper) getPrometheusDeployment() *appsv1.Deployment {
+	storageVolumeSource := v1.VolumeSource{
+		EmptyDir: &v1.EmptyDirVolumeSource{},
+	}
+	strategy := appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType}
+	var securityContext *v1.PodSecurityContext
+	if p.Config.StorageSize != "" {
+		storageVolumeSource = v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: p.getStorageClaimName(),
+			},
+		}
+		// a ReadWriteOnce volume cannot be mounted by the old and the new pod at the same time
+		strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+		// Prometheus runs as user nobody and needs write access to the volume
+		securityContext = &v1.PodSecurityContext{
+			RunAsUser:    int64Ptr(65534),
+			RunAsNonRoot: boolPtr(true),
+			FSGroup:      int64Ptr(65534),
+		}
+	}
+
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "prometheus-deployment",
-			Namespace: "monitoring",
+			Name:      p.Config.DeploymentName,
+			Namespace: p.Config.Namespace,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: int32Ptr(1),
+			Replicas: int32Ptr(p.Config.Prometheus.Replicas),
+			Strategy: strategy,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": "prometheus-server",
-				},
+				MatchLabels: p.Config.SelectorLabels(),
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": "prometheus-server",
-					},
+					Labels: p.Config.SelectorLabels(),
 				},
 				Spec: v1.PodSpec{
+					NodeSelector:    p.Config.Prometheus.NodeSelector,
+					Tolerations:     p.Config.Prometheus.Tolerations,
+					SecurityContext: securityContext,
 					Volumes: []v1.Volume{
 						{
 							Name: "prometheus-config-volume",
 							VolumeSource: v1.VolumeSource{
 								ConfigMap: &v1.ConfigMapVolumeSource{
 									LocalObjectReference: v1.LocalObjectReference{
-										Name: "prometheus-server-conf",
+										Name: p.Config.ConfigMapName,
 									},
 									DefaultMode: int32Ptr(420),
 								},
 							},
 						},
 						{
-							Name: "prometheus-storage-volume",
-							VolumeSource: v1.VolumeSource{
-								EmptyDir: &v1.EmptyDirVolumeSource{},
-							},
+							Name:         "prometheus-storage-volume",
+							VolumeSource: storageVolumeSource,
 						},
 					},
 					Containers: []v1.Container{
 						{
-							Name:  "prometheus",
-							Image: "prom/prometheus:v2.12.0",
-							Args:  []string{"--config.file=/etc/prometheus/prometheus.yml", "--storage.tsdb.path=/prometheus/"},
+							Name:      "prometheus",
+							Image:     p.Config.Prometheus.ImageName(),
+							Args:      p.getPrometheusArgs(),
+							Resources: p.Config.Prometheus.ResourceRequirements(),
 							Ports: []v1.ContainerPort{
 								{
 									ContainerPort: 9090,
@@ -522,104 +686,140 @@ func (p *PrometheusHelper) CreateOrUpdatePrometheusDeployment() error {
 			},
 		},
 	}
-	err := p.createOrUpdateDeployment(deployment)
+	return deployment
+}
+
+func (p *PrometheusHelper) createOrUpdateService(service *v1.Service) error {
+	service.Labels = p.managedLabels(service.Labels)
+	existing, err := p.KubeApi.CoreV1().Services(service.Namespace).Get(service.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.CoreV1().Services(service.Namespace).Create(service)
+		return err
+	}
 	if err != nil {
 		return err
 	}
-
-	service := &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      "prometheus-service",
-			Namespace: "monitoring",
-			Annotations: map[string]string{
-				"prometheus.io/scrape": "true",
-				"prometheus.io/path":   "/",
-				"prometheus.io.port":   "8080",
-			},
-		},
-		Spec: v1.ServiceSpec{
-			Ports: []v1.ServicePort{
-				{
-					Port: 8080,
-					TargetPort: intstr.IntOrString{
-						IntVal: 9090,
-						Type:   intstr.Int,
-					},
-					NodePort: 30000,
-				},
-			},
-			Selector: map[string]string{
-				"app": "prometheus-server",
-			},
-			Type: "NodePort",
-		},
+	if err := p.checkManaged("service", existing.ObjectMeta); err != nil {
+		return err
 	}
-	return p.createOrUpdateService(service)
+	// the cluster IP is immutable and has to be sent with every update
+	service.ResourceVersion = existing.ResourceVersion
+	service.Spec.ClusterIP = existing.Spec.ClusterIP
+	_, err = p.KubeApi.CoreV1().Services(service.Namespace).Update(service)
+	return err
 }
 
-func (p *PrometheusHelper) createOrUpdateService(service *v1.Service) error {
-	_, err := p.KubeApi.CoreV1().Services("monitoring").Create(service)
+func (p *PrometheusHelper) createOrUpdateDeployment(deployment *appsv1.Deployment) error {
+	deployment.Labels = p.managedLabels(deployment.Labels)
+	existing, err := p.KubeApi.AppsV1().Deployments(deployment.Namespace).Get(deployment.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.AppsV1().Deployments(deployment.Namespace).Create(deployment)
+		return err
+	}
 	if err != nil {
-		_, err = p.KubeApi.CoreV1().Services("monitoring").Update(service)
-		if err != nil {
+		return err
+	}
+	if err := p.checkManaged("deployment", existing.ObjectMeta); err != nil {
+		return err
+	}
+	// the selector of a deployment is immutable, so the deployment is recreated if PROMETHEUS_SELECTOR or
+	// ALERT_MANAGER_SELECTOR has changed. The pods of the old selector are removed by the garbage collector.
+	if !apiequality.Semantic.DeepEqual(existing.Spec.Selector, deployment.Spec.Selector) {
+		propagation := metav1.DeletePropagationBackground
+		err := p.KubeApi.AppsV1().Deployments(deployment.Namespace).Delete(deployment.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !apierrors.IsNotFound(err) {
 			return err
 		}
+		_, err = p.KubeApi.AppsV1().Deployments(deployment.Namespace).Create(deployment)
+		return err
 	}
-	return nil
+	deployment.ResourceVersion = existing.ResourceVersion
+	_, err = p.KubeApi.AppsV1().Deployments(deployment.Namespace).Update(deployment)
+	return err
 }
 
-func (p *PrometheusHelper) createOrUpdateDeployment(deployment *appsv1.Deployment) error {
-	_, err := p.KubeApi.AppsV1().Deployments("monitoring").Create(deployment)
+// CreateOrUpdateAlertManagerConfigMap creates the Alertmanager config map. An existing configuration is replaced if
+// it is unchanged since it has been rendered, e.g. to apply another ALERT_WEBHOOK_URL. A configuration that has been
+// modified may contain additional receivers and is kept.
+func (p *PrometheusHelper) CreateOrUpdateAlertManagerConfigMap() error {
+	config, err := p.renderAlertManagerConfig()
 	if err != nil {
-		_, err := p.KubeApi.AppsV1().Deployments("monitoring").Update(deployment)
+		return err
+	}
+	existing, err := p.KubeApi.CoreV1().ConfigMaps(p.Config.AlertManagerNamespace).Get(p.Config.AlertManagerConfigMapName, metav1.GetOptions{})
+	if err == nil {
+		if err := p.checkManaged("config map", existing.ObjectMeta); err != nil {
+			return err
+		}
+		status, err := p.GetInstallationStatus()
 		if err != nil {
 			return err
 		}
+		if status != nil && status.AlertManagerConfigHash != "" && hashOf(existing.Data[alertManagerConfigKey]) == status.AlertManagerConfigHash {
+			existing.Data[alertManagerConfigKey] = config
+		}
+		existing.Labels = p.managedLabels(existing.Labels)
+		_, err = p.KubeApi.CoreV1().ConfigMaps(existing.Namespace).Update(existing)
+		return err
+	}
+	if !apierrors.IsNotFound(err) {
+		return err
 	}
-	return nil
-}
 
-// CreateOrUpdateAlertManagerConfigMap creates or updates the Prometheus config map
-func (p *PrometheusHelper) CreateOrUpdateAlertManagerConfigMap() error {
 	cm := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "alertmanager-config",
-			Namespace: "monitoring",
+			Name:      p.Config.AlertManagerConfigMapName,
+			Namespace: p.Config.AlertManagerNamespace,
+		},
+		Data: map[string]string{
+			alertManagerConfigKey: config,
 		},
-		Data: map[string]string{},
 	}
+	return p.createOrUpdateConfigMap(cm)
+}
 
+// renderAlertManagerConfig returns the configuration of the bundled Alertmanager
+func (p *PrometheusHelper) renderAlertManagerConfig() (string, error) {
+	config, err := GetConfig()
+	if err != nil {
+		return "", err
+	}
 	var configYaml interface{}
-	err := yaml.Unmarshal([]byte(alertManagerYml), &configYaml)
+	err = yaml.Unmarshal([]byte(strings.Replace(alertManagerYml, "$WEBHOOK_URL", config.WebhookURL, -1)), &configYaml)
 	if err != nil {
-		return err
+		return "", err
 	}
 	yamlString, err := yaml.Marshal(configYaml)
 	if err != nil {
-		return err
+		return "", err
 	}
-	cm.Data["config.yml"] = string(yamlString)
-
-	return p.createOrUpdateConfigMap(cm)
+	return string(yamlString), nil
 }
 
 func (p *PrometheusHelper) createOrUpdateConfigMap(cm *v1.ConfigMap) error {
-	_, err := p.KubeApi.CoreV1().ConfigMaps("monitoring").Create(cm)
+	cm.Labels = p.managedLabels(cm.Labels)
+	existing, err := p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Get(cm.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Create(cm)
+		return err
+	}
 	if err != nil {
-		_, err := p.KubeApi.CoreV1().ConfigMaps("monitoring").Update(cm)
-		if err != nil {
-			return err
-		}
+		return err
 	}
-	return nil
+	if err := p.checkManaged("config map", existing.ObjectMeta); err != nil {
+		return err
+	}
+	cm.ResourceVersion = existing.ResourceVersion
+	_, err = p.KubeApi.CoreV1().ConfigMaps(cm.Namespace).Update(cm)
+	return err
 }
 
-// CreateOrUpdateAlertManagerTemplatesConfigMap creates or updates the Prometheus config map
+// CreateOrUpdateAlertManagerTemplatesConfigMap creates or updates the Alertmanager templates config map
 func (p *PrometheusHelper) CreateOrUpdateAlertManagerTemplatesConfigMap() error {
 	cm := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "alertmanager-templates",
-			Namespace: "monitoring",
+			Name:      p.Config.AlertManagerTemplatesName,
+			Namespace: p.Config.AlertManagerNamespace,
 		},
 		Data: map[string]string{},
 	}
@@ -630,34 +830,42 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerTemplatesConfigMap() error
 	return p.createOrUpdateConfigMap(cm)
 }
 
-// CreateOrUpdateAlertManagerDeployment creates or updates the Prometheus config map
+// CreateOrUpdateAlertManagerDeployment creates or updates the Alertmanager deployment
 func (p *PrometheusHelper) CreateOrUpdateAlertManagerDeployment() error {
+	return p.createOrUpdateDeployment(p.getAlertManagerDeployment())
+}
+
+// getAlertManagerDeployment returns the Alertmanager deployment rendered from the current settings
+func (p *PrometheusHelper) getAlertManagerDeployment() *appsv1.Deployment {
+	// Alertmanager does not reload its configuration, so a changed configuration rolls out new pods
+	config, _ := p.renderAlertManagerConfig()
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "alertmanager",
-			Namespace: "monitoring",
+			Name:      p.Config.AlertManagerDeploymentName,
+			Namespace: p.Config.AlertManagerNamespace,
 		},
 		Spec: appsv1.DeploymentSpec{
-			Replicas: int32Ptr(1),
+			Replicas: int32Ptr(p.Config.AlertManager.Replicas),
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": "alertmanager",
-				},
+				MatchLabels: p.Config.AlertManagerSelectorLabels(),
 			},
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": "alertmanager",
+					Labels: p.Config.AlertManagerSelectorLabels(),
+					Annotations: map[string]string{
+						configHashAnnotation: hashOf(config),
 					},
 				},
 				Spec: v1.PodSpec{
+					NodeSelector: p.Config.AlertManager.NodeSelector,
+					Tolerations:  p.Config.AlertManager.Tolerations,
 					Volumes: []v1.Volume{
 						{
 							Name: "config-volume",
 							VolumeSource: v1.VolumeSource{
 								ConfigMap: &v1.ConfigMapVolumeSource{
 									LocalObjectReference: v1.LocalObjectReference{
-										Name: "alertmanager-config",
+										Name: p.Config.AlertManagerConfigMapName,
 									},
 								},
 							},
@@ -667,7 +875,7 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerDeployment() error {
 							VolumeSource: v1.VolumeSource{
 								ConfigMap: &v1.ConfigMapVolumeSource{
 									LocalObjectReference: v1.LocalObjectReference{
-										Name: "alertmanager-templates",
+										Name: p.Config.AlertManagerTemplatesName,
 									},
 								},
 							},
@@ -681,8 +889,9 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerDeployment() error {
 					},
 					Containers: []v1.Container{
 						{
-							Name:  "alertmanager",
-							Image: "prom/alertmanager:latest",
+							Name:      "alertmanager",
+							Image:     p.Config.AlertManager.ImageName(),
+							Resources: p.Config.AlertManager.ResourceRequirements(),
 							Args: []string{
 								"--config.file=/etc/alertmanager/config.yml",
 								"--storage.path=/alertmanager",
@@ -713,19 +922,15 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerDeployment() error {
 			},
 		},
 	}
-	err := p.createOrUpdateDeployment(deployment)
-	if err != nil {
-		return err
-	}
-	return nil
+	return deployment
 }
 
-// CreateOrUpdateAlertManagerService creates or updates the Prometheus config map
+// CreateOrUpdateAlertManagerService creates or updates the Alertmanager service
 func (p *PrometheusHelper) CreateOrUpdateAlertManagerService() error {
 	service := &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "alertmanager",
-			Namespace: "monitoring",
+			Name:      p.Config.AlertManagerServiceName,
+			Namespace: p.Config.AlertManagerNamespace,
 			Annotations: map[string]string{
 				"prometheus.io/scrape": "true",
 				"prometheus.io/path":   "/",
@@ -743,10 +948,8 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerService() error {
 					NodePort: 31000,
 				},
 			},
-			Selector: map[string]string{
-				"app": "alertmanager",
-			},
-			Type: "NodePort",
+			Selector: p.Config.AlertManagerSelectorLabels(),
+			Type:     "NodePort",
 		},
 	}
 
@@ -754,3 +957,7 @@ func (p *PrometheusHelper) CreateOrUpdateAlertManagerService() error {
 }
 
 func int32Ptr(i int32) *int32 { return &i }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
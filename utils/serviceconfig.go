@@ -0,0 +1,444 @@
+package utils
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// configFileEnv is the path of an optional YAML file holding settings by their environment variable names
+const configFileEnv = "CONFIG_FILE"
+
+const installModeAuto = "auto"
+
+// InstallModeNever disables the installation of the bundled Prometheus
+const InstallModeNever = "never"
+
+// MetricProfileDefault builds the default SLI queries from METRIC_REQUESTS_TOTAL and METRIC_RESPONSE_TIME_BUCKET
+const MetricProfileDefault = "default"
+
+// MetricProfiles are the metric conventions the default SLI queries can be built for
+var MetricProfiles = []string{MetricProfileDefault, "istio", "linkerd", "nginx-ingress", "grpc", "micrometer"}
+
+// ServiceConfig holds all settings of the prometheus-service. Every setting is read from the environment variable
+// given by its envconfig tag. The same names can be used as keys of the YAML file given by CONFIG_FILE and, in lower
+// case with dashes, as command line flags. Flags take precedence over environment variables, which take precedence
+// over the file.
+type ServiceConfig struct {
+	PrometheusConfig
+
+	// Port receives Prometheus alerts and events and serves the HTTP API
+	Port int `envconfig:"PORT" default:"8080"`
+	// ReceiverPort receives the cloud events forwarded from Port
+	ReceiverPort int    `envconfig:"RCV_PORT" default:"8081"`
+	ReceiverPath string `envconfig:"RCV_PATH" default:"/"`
+
+	ConfigurationServiceURL string `envconfig:"CONFIGURATION_SERVICE" default:"configuration-service.keptn.svc.cluster.local:8080"`
+	EventBrokerURL          string `envconfig:"EVENTBROKER"`
+	APIURL                  string `envconfig:"API"`
+	// AdminToken has to be sent as bearer token to the endpoints that change the Prometheus installation. These
+	// endpoints are disabled if it is not set.
+	AdminToken string `envconfig:"ADMIN_TOKEN" secret:"true"`
+	// KeptnNamespace holds the config maps with custom SLI queries
+	KeptnNamespace string `envconfig:"KEPTN_NAMESPACE" default:"keptn"`
+
+	// KubeConfig is the path of a kubeconfig file used outside of the cluster
+	KubeConfig  string `envconfig:"KUBECONFIG"`
+	KubeContext string `envconfig:"KUBE_CONTEXT"`
+
+	// InstallMode defines whether the bundled Prometheus is installed if no installation is found (auto) or not (never)
+	InstallMode string `envconfig:"PROMETHEUS_INSTALL_MODE" default:"auto"`
+	// DetectServices are the Prometheus service names that are searched in all namespaces
+	DetectServices  []string      `envconfig:"PROMETHEUS_DETECT_SERVICES" default:"prometheus-server,prometheus-operated,prometheus-kube-prometheus-prometheus,prometheus"`
+	ConfigRevisions int           `envconfig:"PROMETHEUS_CONFIG_REVISIONS" default:"10"`
+	ReadyTimeout    time.Duration `envconfig:"PROMETHEUS_READY_TIMEOUT" default:"2m"`
+	VerifyTimeout   time.Duration `envconfig:"PROMETHEUS_VERIFY_TIMEOUT" default:"30s"`
+
+	// QueryRange is the range of the default SLI queries and the value of $DURATION_SECONDS
+	QueryRange string `envconfig:"SLI_QUERY_RANGE" default:"180s"`
+	// AlertDuration is how long an SLO has to be violated before an alert fires
+	AlertDuration string `envconfig:"ALERT_DURATION" default:"10m"`
+	// AnomalyWindow is the learning window of the z-score anomaly criteria
+	AnomalyWindow string `envconfig:"ANOMALY_LEARNING_WINDOW" default:"1d"`
+	// WebhookURL is the receiver of the alerts sent by the bundled Alertmanager
+	WebhookURL         string `envconfig:"ALERT_WEBHOOK_URL" default:"http://prometheus-service.keptn.svc.cluster.local:8080"`
+	RequestsMetric     string `envconfig:"METRIC_REQUESTS_TOTAL" default:"http_requests_total"`
+	ResponseTimeMetric string `envconfig:"METRIC_RESPONSE_TIME_BUCKET" default:"http_response_time_milliseconds_bucket"`
+	// MetricProfile is the metric profile of the services that do not select one in prometheus/sli.yaml
+	MetricProfile string `envconfig:"METRIC_PROFILE" default:"default"`
+	// ApdexThreshold is the response time up to which a request satisfies the users in the apdex SLI. It has to be a
+	// bucket boundary of the response time histogram, as has four times the threshold.
+	ApdexThreshold time.Duration `envconfig:"APDEX_THRESHOLD" default:"500ms"`
+	// HealthAlerts adds alerts for scrape targets that are down and services that stop exporting metrics
+	HealthAlerts bool `envconfig:"HEALTH_ALERTS" default:"false"`
+
+	// ReconcileInterval defines how often the managed Prometheus configuration is checked for drift, 0 disables it
+	ReconcileInterval time.Duration `envconfig:"RECONCILE_INTERVAL" default:"10m"`
+
+	// SLOPollInterval defines how often the slo.yaml files of the monitored services are checked for changes, 0
+	// disables it
+	SLOPollInterval time.Duration `envconfig:"SLO_POLL_INTERVAL" default:"1m"`
+
+	// ReloadInterval defines how often the configuration file is checked for changes, 0 disables the check
+	ReloadInterval time.Duration `envconfig:"CONFIG_RELOAD_INTERVAL" default:"1m"`
+}
+
+// configSetting is a leaf field of the ServiceConfig together with its environment variable name
+type configSetting struct {
+	key          string
+	field        reflect.Value
+	defaultValue string
+	secret       bool
+}
+
+var serviceConfigMutex sync.RWMutex
+var serviceConfig *ServiceConfig
+
+// serviceConfigFile is the path of the YAML file given by flag
+var serviceConfigFile string
+
+// baseEnv holds the settings given as environment variables when the service was started
+var baseEnv map[string]string
+
+// flagValues holds the settings given as command line flags
+var flagValues = map[string]string{}
+
+// flagValue stores a command line flag by the environment variable name of its setting
+type flagValue struct {
+	key string
+}
+
+func (f flagValue) String() string {
+	return flagValues[f.key]
+}
+
+func (f flagValue) Set(value string) error {
+	flagValues[f.key] = value
+	return nil
+}
+
+// RegisterConfigFlags adds a command line flag for each setting to the flag set, e.g. --prometheus-namespace
+func RegisterConfigFlags(flags *flag.FlagSet) {
+	flags.StringVar(&serviceConfigFile, "config", "", "path of a YAML file with settings (CONFIG_FILE)")
+	for _, setting := range getConfigSettings(&ServiceConfig{}) {
+		name := strings.Replace(strings.ToLower(setting.key), "_", "-", -1)
+		flags.Var(flagValue{key: setting.key}, name, "overrides "+setting.key)
+	}
+}
+
+// GetConfig returns the current configuration of the service, which is loaded on first use
+func GetConfig() (*ServiceConfig, error) {
+	serviceConfigMutex.RLock()
+	config := serviceConfig
+	serviceConfigMutex.RUnlock()
+	if config != nil {
+		return config, nil
+	}
+	return LoadConfig()
+}
+
+// LoadConfig reads and validates the configuration. The current configuration is only replaced if the new one is
+// valid, so a broken configuration file does not affect a running service.
+func LoadConfig() (*ServiceConfig, error) {
+	serviceConfigMutex.Lock()
+	defer serviceConfigMutex.Unlock()
+
+	settings := getConfigSettings(&ServiceConfig{})
+	if baseEnv == nil {
+		baseEnv = map[string]string{}
+		for _, setting := range settings {
+			if value, ok := os.LookupEnv(setting.key); ok {
+				baseEnv[setting.key] = value
+			}
+		}
+	}
+
+	fileValues, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, setting := range settings {
+		if value, ok := flagValues[setting.key]; ok {
+			values[setting.key] = value
+		} else if value, ok := baseEnv[setting.key]; ok {
+			values[setting.key] = value
+		} else if value, ok := fileValues[setting.key]; ok {
+			values[setting.key] = value
+		}
+	}
+
+	config := &ServiceConfig{}
+	if err := processConfig(config, values); err != nil {
+		return nil, fmt.Errorf("Failed to process configuration: %s", err.Error())
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	serviceConfig = config
+	return config, nil
+}
+
+// processConfig sets the settings of a configuration to the given values by their environment variable names, or to
+// their defaults. The values are converted like envconfig does, but the environment of the process, which is shared
+// with all goroutines, is neither read nor modified.
+func processConfig(config *ServiceConfig, values map[string]string) error {
+	for _, setting := range getConfigSettings(config) {
+		value, ok := values[setting.key]
+		if !ok {
+			value = setting.defaultValue
+		}
+		if err := setConfigField(setting.field, value); err != nil {
+			return fmt.Errorf("invalid value %q of %s: %s", value, setting.key, err.Error())
+		}
+	}
+	return nil
+}
+
+// setConfigField converts a value to the type of a setting. Empty values leave other settings than strings unset.
+func setConfigField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+	if value == "" {
+		return nil
+	}
+	if decoder, ok := field.Addr().Interface().(envconfig.Decoder); ok {
+		return decoder.Decode(value)
+	}
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if field.Type() == reflect.TypeOf(time.Duration(0)) {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(duration))
+			return nil
+		}
+		number, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(number)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		items := strings.Split(value, ",")
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigField(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(value, ",") {
+			kv := strings.SplitN(pair, ":", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid map item %q", pair)
+			}
+			key := reflect.New(field.Type().Key()).Elem()
+			if err := setConfigField(key, kv[0]); err != nil {
+				return err
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setConfigField(elem, kv[1]); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		field.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type().String())
+	}
+	return nil
+}
+
+// GetConfigFile returns the path of the configuration file, or an empty string if no file is used
+func GetConfigFile() string {
+	if serviceConfigFile != "" {
+		return serviceConfigFile
+	}
+	return os.Getenv(configFileEnv)
+}
+
+func readConfigFile() (map[string]string, error) {
+	path := GetConfigFile()
+	values := map[string]string{}
+	if path == "" {
+		return values, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read configuration file %s: %s", path, err.Error())
+	}
+	fileValues := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &fileValues); err != nil {
+		return nil, fmt.Errorf("Could not parse configuration file %s: %s", path, err.Error())
+	}
+
+	known := map[string]bool{}
+	for _, setting := range getConfigSettings(&ServiceConfig{}) {
+		known[setting.key] = true
+	}
+	for key, value := range fileValues {
+		key = strings.ToUpper(key)
+		if !known[key] {
+			return nil, fmt.Errorf("Unknown setting %s in configuration file %s", key, path)
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case map[interface{}]interface{}:
+			var items []string
+			for k, item := range v {
+				items = append(items, fmt.Sprintf("%v:%v", k, item))
+			}
+			sort.Strings(items)
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+func (c *ServiceConfig) validate() error {
+	if err := c.PrometheusConfig.validate(); err != nil {
+		return err
+	}
+	for _, port := range []int{c.Port, c.ReceiverPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("Invalid port %d", port)
+		}
+	}
+	if c.Port == c.ReceiverPort {
+		return fmt.Errorf("PORT and RCV_PORT must differ, both are %d", c.Port)
+	}
+	if c.InstallMode != installModeAuto && c.InstallMode != InstallModeNever {
+		return fmt.Errorf("Invalid PROMETHEUS_INSTALL_MODE %s, must be %s or %s", c.InstallMode, installModeAuto, InstallModeNever)
+	}
+	if c.ConfigRevisions < 1 {
+		return fmt.Errorf("Invalid PROMETHEUS_CONFIG_REVISIONS %d, at least one revision has to be kept", c.ConfigRevisions)
+	}
+	if c.ReadyTimeout <= 0 || c.VerifyTimeout <= 0 {
+		return errors.New("PROMETHEUS_READY_TIMEOUT and PROMETHEUS_VERIFY_TIMEOUT must be positive")
+	}
+	if c.ReloadInterval < 0 || c.ReconcileInterval < 0 || c.SLOPollInterval < 0 {
+		return errors.New("CONFIG_RELOAD_INTERVAL, RECONCILE_INTERVAL and SLO_POLL_INTERVAL must not be negative")
+	}
+	if _, err := model.ParseDuration(c.QueryRange); err != nil {
+		return fmt.Errorf("Invalid SLI_QUERY_RANGE %s: %s", c.QueryRange, err.Error())
+	}
+	if _, err := model.ParseDuration(c.AlertDuration); err != nil {
+		return fmt.Errorf("Invalid ALERT_DURATION %s: %s", c.AlertDuration, err.Error())
+	}
+	if _, err := model.ParseDuration(c.AnomalyWindow); err != nil {
+		return fmt.Errorf("Invalid ANOMALY_LEARNING_WINDOW %s: %s", c.AnomalyWindow, err.Error())
+	}
+	if _, err := url.ParseRequestURI(c.WebhookURL); err != nil {
+		return fmt.Errorf("Invalid ALERT_WEBHOOK_URL: %s", err.Error())
+	}
+	if !model.IsValidMetricName(model.LabelValue(c.RequestsMetric)) || !model.IsValidMetricName(model.LabelValue(c.ResponseTimeMetric)) {
+		return fmt.Errorf("Invalid metric names %s and %s", c.RequestsMetric, c.ResponseTimeMetric)
+	}
+	if c.ApdexThreshold <= 0 {
+		return errors.New("APDEX_THRESHOLD must be positive")
+	}
+	if !IsMetricProfile(c.MetricProfile) {
+		return fmt.Errorf("Invalid METRIC_PROFILE %s, must be one of %s", c.MetricProfile, strings.Join(MetricProfiles, ", "))
+	}
+	return nil
+}
+
+// Summary lists all settings sorted by name. Credentials in URLs and settings marked as secret are redacted.
+func (c *ServiceConfig) Summary() string {
+	var lines []string
+	for _, setting := range getConfigSettings(c) {
+		value := fmt.Sprint(setting.field.Interface())
+		if setting.secret && value != "" {
+			value = "<redacted>"
+		} else if u, err := url.Parse(value); err == nil && u.User != nil {
+			value = strings.Replace(value, u.User.String()+"@", "<redacted>@", 1)
+		}
+		lines = append(lines, setting.key+"="+value)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// getConfigSettings returns the leaf fields of a configuration with the environment variable names used by envconfig
+func getConfigSettings(config *ServiceConfig) []configSetting {
+	return appendConfigSettings(nil, "", reflect.ValueOf(config).Elem())
+}
+
+func appendConfigSettings(settings []configSetting, prefix string, value reflect.Value) []configSetting {
+	decoderType := reflect.TypeOf((*envconfig.Decoder)(nil)).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		fieldType := value.Type().Field(i)
+		if fieldType.PkgPath != "" {
+			continue
+		}
+		key := strings.ToUpper(fieldType.Tag.Get("envconfig"))
+		if key == "" {
+			key = strings.ToUpper(fieldType.Name)
+		}
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		isDecoder := reflect.PtrTo(field.Type()).Implements(decoderType)
+		if field.Kind() == reflect.Struct && !isDecoder {
+			innerPrefix := key
+			if fieldType.Anonymous {
+				innerPrefix = prefix
+			}
+			settings = appendConfigSettings(settings, innerPrefix, field)
+			continue
+		}
+		settings = append(settings, configSetting{
+			key:          key,
+			field:        field,
+			defaultValue: fieldType.Tag.Get("default"),
+			secret:       fieldType.Tag.Get("secret") == "true",
+		})
+	}
+	return settings
+}
+
+// IsMetricProfile returns whether name is one of the MetricProfiles
+func IsMetricProfile(name string) bool {
+	for _, profile := range MetricProfiles {
+		if profile == name {
+			return true
+		}
+	}
+	return false
+}
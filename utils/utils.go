@@ -3,19 +3,18 @@ package utils
 import (
 	"fmt"
 	"net/url"
-	"os"
 )
 
-// GetServiceEndpoint retrieves an endpoint stored in an environment variable and sets http as default scheme
-func GetServiceEndpoint(service string) (url.URL, error) {
-	url, err := url.Parse(os.Getenv(service))
+// GetServiceEndpoint parses the address of a service and sets http as default scheme
+func GetServiceEndpoint(endpoint string) (url.URL, error) {
+	parsed, err := url.Parse(endpoint)
 	if err != nil {
-		return *url, fmt.Errorf("Failed to retrieve value from ENVIRONMENT_VARIABLE: %s", service)
+		return url.URL{}, fmt.Errorf("Failed to parse endpoint %s: %s", endpoint, err.Error())
 	}
 
-	if url.Scheme == "" {
-		url.Scheme = "http"
+	if parsed.Scheme == "" {
+		parsed.Scheme = "http"
 	}
 
-	return *url, nil
+	return *parsed, nil
 }